@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jvsena42/memento/internal/backup"
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// runBackup snapshots the SQLite database into the backup directory and
+// rotates it, or lists the backups it holds
+func runBackup(args []string) int {
+	cfg, err := config.LoadStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 1
+	}
+
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := flags.String("dir", cfg.BackupDir, "backup directory (default BACKUP_DIR)")
+	list := flags.Bool("list", false, "list the backups instead of taking one")
+	noRotate := flags.Bool("no-rotate", false, "keep every backup")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: memento backup [-dir DIR] [-list] [-no-rotate], with BACKUP_DIR or -dir set")
+		return 2
+	}
+	cfg.BackupDir = *dir
+
+	if *list {
+		archives, err := backup.List(cfg.BackupDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list backups: %v\n", err)
+			return 1
+		}
+		for _, archive := range archives {
+			fmt.Printf("%s  %10d  %s\n", archive.TakenAt.Format("2006-01-02 15:04:05"), archive.Size, archive.Path)
+		}
+		return 0
+	}
+
+	if _, ok := storage.SQLitePath(cfg.DatabaseURL); !ok {
+		fmt.Fprintln(os.Stderr, "backups cover SQLite databases only; back PostgreSQL up with pg_dump")
+		return 1
+	}
+
+	db, err := storage.Open(cfg.DatabaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	job := backup.Job{DB: db, Config: cfg, Clock: clock.System}
+	if *noRotate {
+		archive, err := backup.Create(db, cfg.BackupDir, clock.System.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "backup failed: %v\n", err)
+			return 1
+		}
+		fmt.Println(archive.Path)
+		return 0
+	}
+
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "backup failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runRestore puts a backup in place of the SQLite database while no bot runs
+func runRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: memento restore <backup.db.gz>")
+		return 2
+	}
+
+	dbPath, ok := storage.SQLitePath(config.DatabaseURL())
+	if !ok {
+		fmt.Fprintln(os.Stderr, "restores cover SQLite databases only; restore PostgreSQL with pg_restore")
+		return 1
+	}
+
+	previous, err := backup.Restore(args[0], dbPath, clock.System.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("restored %s into %s; the previous database was kept as %s\n", args[0], dbPath, previous)
+	return 0
+}
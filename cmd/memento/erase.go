@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// runErase forgets a user on request and prints the receipt of the erasure
+func runErase(args []string) int {
+	flags := flag.NewFlagSet("erase", flag.ContinueOnError)
+	handle := flags.String("handle", "", "@handle of the user, to also match capsules saved before author IDs were recorded")
+	dryRun := flags.Bool("dry-run", false, "count what would be erased, and change nothing")
+	list := flags.Bool("list", false, "list the erasure receipts, newest first")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *list == (flags.NArg() == 1) || flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: memento erase [-handle name] [-dry-run] <user-id>\n       memento erase -list")
+		return 2
+	}
+
+	db, store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	if *list {
+		erasures, err := store.Erasures()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list erasures: %v\n", err)
+			return 1
+		}
+		if len(erasures) == 0 {
+			fmt.Println("No erasures")
+		}
+		for _, e := range erasures {
+			printErasure(&e)
+		}
+		return 0
+	}
+
+	receipt, err := store.EraseUser(storage.ErasureRequest{
+		UserID: flags.Arg(0),
+		Handle: *handle,
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erasure failed: %v\n", err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Println("Dry run, nothing was erased")
+	}
+	printErasure(receipt)
+	return 0
+}
+
+func printErasure(e *storage.Erasure) {
+	if e.ID != 0 {
+		fmt.Printf("Erasure #%d\n", e.ID)
+	}
+	fmt.Printf("  subject:             hmac-sha256:%s\n", e.SubjectHash)
+	fmt.Printf("  erased at:           %s\n", e.ErasedAt.UTC().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  capsules deleted:    %d\n", e.CapsulesDeleted)
+	fmt.Printf("  collections deleted: %d\n", e.CollectionsDeleted)
+	fmt.Printf("  preferences deleted: %d\n", e.PreferencesDeleted)
+	fmt.Printf("  dry-run posts:       %d\n", e.DryRunPostsDeleted)
+	fmt.Printf("  tweets tombstoned:   %d\n", e.TweetsTombstoned)
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	_ "time/tzdata" // delivery time zones must resolve in minimal containers
 
+	"github.com/jvsena42/memento/internal/backup"
 	"github.com/jvsena42/memento/internal/bot"
+	"github.com/jvsena42/memento/internal/clock"
 	"github.com/jvsena42/memento/internal/config"
 	"github.com/jvsena42/memento/internal/storage"
 	"github.com/jvsena42/memento/internal/twitter"
@@ -21,6 +25,38 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+		case "simulate":
+			os.Exit(runSimulate(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "search":
+			os.Exit(runSearch(os.Args[2:]))
+		case "erase":
+			os.Exit(runErase(os.Args[2:]))
+		case "timeline":
+			os.Exit(runTimeline(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\nusage: memento [run | simulate | migrate | backup | restore | export | import | search | erase | timeline]\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
+	runBot()
+}
+
+// runBot runs the bot until it receives SIGINT or SIGTERM
+func runBot() {
 	cfg, err := config.Load()
 	if err != nil {
 		slog.Error("failed to load config", "error", err)
@@ -29,36 +65,77 @@ func main() {
 
 	slog.Info("configuration loaded",
 		"dev_mode", cfg.DevMode,
+		"dry_run", cfg.DryRun,
+		"instance_id", cfg.InstanceID,
 		"poll_interval", cfg.PollInterval,
+		"republish_years", cfg.RepublishYears,
 		"republish_delay", cfg.RepublishDelay,
-		"database", cfg.DatabasePath,
+		"sweep_interval", cfg.SweepInterval,
+		"post_limit_daily", cfg.PostLimitDaily,
+		"post_limit_monthly", cfg.PostLimitMonthly,
 	)
 
+	// Hold the SQLite database so it can't be restored over while running
+	dbPath, isSQLite := storage.SQLitePath(cfg.DatabaseURL)
+	if isSQLite {
+		lock, err := storage.LockDatabase(dbPath, false)
+		if err != nil {
+			slog.Error("failed to lock database", "error", err)
+			os.Exit(1)
+		}
+		defer lock.Unlock()
+	}
+
 	// Initialize DB
-	db, err := storage.New(cfg.DatabasePath)
+	db, err := storage.Open(cfg.DatabaseURL)
 	if err != nil {
 		slog.Error("failed to open database", "error", err)
 		os.Exit(1)
 	}
+	slog.Info("database opened", "dialect", db.Dialect)
 
-	if err := db.Migrate("migrations"); err != nil {
+	if err := db.Migrate(); err != nil {
 		slog.Error("failed to run migrations", "error", err)
 		os.Exit(1)
 	}
 
 	slog.Info("database ready")
 
-	capsuleStore := storage.NewCapsuleStore(db)
+	keys, err := storage.OpenKeyring(db, clock.System, cfg.MasterKey, cfg.MasterKeyPrevious)
+	if err != nil {
+		slog.Error("failed to load encryption keys", "error", err)
+		os.Exit(1)
+	}
+
+	capsuleStore := storage.NewCapsuleStore(db, clock.System, keys, cfg.ErasureKey)
+
+	budget := &bot.Budget{
+		Store:        capsuleStore,
+		DailyLimit:   cfg.PostLimitDaily,
+		MonthlyLimit: cfg.PostLimitMonthly,
+		ReplyReserve: cfg.PostReplyReserve,
+		Clock:        clock.System,
+	}
 
 	twitterClient := twitter.NewClient(cfg)
+	if cfg.DryRun {
+		// Reads still hit the API, tweets only land in dry_run_posts
+		twitterClient.DryRun = capsuleStore
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	// The handler wakes the scheduler up whenever it creates a capsule
+	wakeup := make(chan struct{}, 1)
+
 	botHandler := bot.Handler{
 		Client:       twitterClient,
 		CapsuleStore: capsuleStore,
 		Config:       cfg,
+		Wakeup:       wakeup,
+		Budget:       budget,
+		Clock:        clock.System,
 	}
 
 	// Launch goroutines with wg tracking:
@@ -72,6 +149,9 @@ func main() {
 		Client:       twitterClient,
 		CapsuleStore: capsuleStore,
 		Config:       cfg,
+		Wakeup:       wakeup,
+		Budget:       budget,
+		Clock:        clock.System,
 	}
 	wg.Add(1)
 	go func() {
@@ -79,6 +159,46 @@ func main() {
 		botScheduler.StartScheduler(ctx)
 	}()
 
+	botVerifier := bot.Verifier{
+		Client:       twitterClient,
+		CapsuleStore: capsuleStore,
+		Config:       cfg,
+		Clock:        clock.System,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		botVerifier.StartVerifier(ctx)
+	}()
+
+	reencrypter := bot.Reencrypter{
+		CapsuleStore: capsuleStore,
+		Config:       cfg,
+		Clock:        clock.System,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reencrypter.StartReencrypter(ctx)
+	}()
+
+	if cfg.BackupDir != "" {
+		if isSQLite {
+			backupJob := backup.Job{
+				DB:     db,
+				Config: cfg,
+				Clock:  clock.System,
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				backupJob.StartBackups(ctx)
+			}()
+		} else {
+			slog.Warn("scheduled backups cover SQLite only, back PostgreSQL up with pg_dump")
+		}
+	}
+
 	slog.Info("memento bot started 🕰️")
 
 	// Set up signal listening:
@@ -90,8 +210,8 @@ func main() {
 	slog.Info("shutting down...")
 
 	// Trigger shutdown:
-	cancel()   // signals both goroutines via ctx.Done()
-	wg.Wait()  // waits until both goroutines return
+	cancel()   // signals every goroutine via ctx.Done()
+	wg.Wait()  // waits until all goroutines return
 	db.Close() // clean up database
 	slog.Info("shutdown complete")
 }
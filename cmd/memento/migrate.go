@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+const migrateUsage = "usage: memento migrate [status | up | down [steps] | to <version>]"
+
+// runMigrate shows or moves the schema version of the database named by
+// DATABASE_URL or DATABASE_PATH
+func runMigrate(args []string) int {
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
+	}
+
+	db, err := storage.Open(config.DatabaseURL())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch {
+	case command == "status" && len(args) == 0:
+		err = printMigrationStatus(db)
+	case command == "up" && len(args) == 0:
+		err = db.Migrate()
+	case command == "down" && len(args) <= 1:
+		steps := 1
+		if len(args) == 1 {
+			steps, err = strconv.Atoi(args[0])
+			if err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps %q\n\n%s\n", args[0], migrateUsage)
+				return 2
+			}
+		}
+		err = db.MigrateDown(steps)
+	case command == "to" && len(args) == 1:
+		version, convErr := strconv.Atoi(args[0])
+		if convErr != nil || version < 0 {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n\n%s\n", args[0], migrateUsage)
+			return 2
+		}
+		err = db.MigrateTo(version)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s failed: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(db *storage.DB) error {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dialect: %s\n\n", db.Dialect)
+	fmt.Println("Version  State     Down  Applied at           Migration")
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case status.Modified:
+			state = "modified"
+		case status.Applied:
+			state = "applied"
+		}
+
+		down := "no"
+		if status.Down != "" {
+			down = "yes"
+		}
+
+		appliedAt := "-"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Printf("%7d  %-8s  %-4s  %-19s  %s\n", status.Version, state, down, appliedAt, status.Name)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// runSearch prints the capsules whose snapshot, thread or handles match
+// every term of the query, best match first. Capsules encrypted at rest only
+// match on their handles, which it warns about.
+func runSearch(args []string) int {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, "most capsules to show")
+	filters := addFilterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *limit < 1 {
+		fmt.Fprintln(os.Stderr, "usage: memento search [-limit n] [filters] <terms…>")
+		return 2
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	results, err := store.Search(strings.Join(flags.Args(), " "), filter, *limit)
+	if errors.Is(err, storage.ErrEmptyQuery) {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "search failed: %v\n", err)
+		return 1
+	}
+
+	// Content search can't see snapshots encrypted at rest; say so rather
+	// than let them pass for missing
+	encrypted, err := store.EncryptedCapsules(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "search failed: %v\n", err)
+		return 1
+	}
+	if encrypted > 0 {
+		fmt.Fprintf(os.Stderr, "Note: %d capsules are encrypted at rest; their text isn't searchable, only their handles are\n", encrypted)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No capsules found")
+		return 0
+	}
+	for _, r := range results {
+		fmt.Printf("#%d  %s  @%s saved @%s's tweet %s on %s\n", r.ID, r.Status, r.RequesterHandle, r.TweetAuthor,
+			r.TweetID, r.CreatedAt.UTC().Format("2006-01-02"))
+		if r.Snippet != "" {
+			fmt.Printf("    %s\n", strings.Join(strings.Fields(r.Snippet), " "))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jvsena42/memento/internal/simulate"
+)
+
+// runSimulate fast-forwards the bot through years of mentions and republishes
+// against a fake API and prints what got posted
+func runSimulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	start := flags.String("start", "2026-01-01", "virtual start date (YYYY-MM-DD)")
+	years := flags.Int("years", 6, "how many years to simulate")
+	mentions := flags.Int("mentions", 200, "mentions spread over the first year")
+	requesters := flags.Int("requesters", 50, "distinct users tagging the bot")
+	deleteRate := flags.Float64("delete-rate", 0.1, "share of saved tweets deleted before they come back")
+	seed := flags.Int64("seed", 1, "random seed of the scenario")
+	showPosts := flags.Bool("posts", false, "print every post")
+	verbose := flags.Bool("v", false, "log what the bot does")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	startAt, err := time.Parse("2006-01-02", *start)
+	if err != nil || *years < 1 || *mentions < 0 || *requesters < 1 || *deleteRate < 0 || *deleteRate > 1 {
+		fmt.Fprintln(os.Stderr, "invalid simulation options")
+		flags.Usage()
+		return 2
+	}
+
+	// The bot logs every step; keep the report readable unless asked
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := simulate.Run(ctx, simulate.Options{
+		Start:      startAt,
+		Years:      *years,
+		Mentions:   *mentions,
+		Requesters: *requesters,
+		DeleteRate: *deleteRate,
+		Seed:       *seed,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simulation failed: %v\n", err)
+		return 1
+	}
+
+	printReport(report, *showPosts)
+	return 0
+}
+
+func printReport(report *simulate.Report, showPosts bool) {
+	fmt.Printf("Simulated %s to %s\n", report.Start.Format("2006-01-02"), report.End.Format("2006-01-02"))
+	fmt.Printf("Mentions: %d, tweets deleted: %d, posts: %d\n\n", report.Mentions, report.Deleted, len(report.Posts))
+
+	replies := make(map[int]int)
+	republishes := make(map[int]int)
+	var yearsSeen []int
+	for _, post := range report.Posts {
+		year := post.PostedAt.Year()
+		if replies[year] == 0 && republishes[year] == 0 {
+			yearsSeen = append(yearsSeen, year)
+		}
+		if post.Republish {
+			republishes[year]++
+		} else {
+			replies[year]++
+		}
+	}
+
+	fmt.Println("Year  Replies  Republishes")
+	for _, year := range yearsSeen {
+		fmt.Printf("%d  %7d  %11d\n", year, replies[year], republishes[year])
+	}
+
+	var statuses []string
+	for status := range report.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	fmt.Println("\nCapsules by status:")
+	for _, status := range statuses {
+		fmt.Printf("  %-18s %d\n", status, report.Statuses[status])
+	}
+
+	if !showPosts {
+		return
+	}
+	fmt.Println("\nPosts:")
+	for _, post := range report.Posts {
+		target := ""
+		if post.QuoteTweetID != "" {
+			target += " quote=" + post.QuoteTweetID
+		}
+		if post.ReplyToID != "" {
+			target += " reply_to=" + post.ReplyToID
+		}
+		fmt.Printf("%s %s%s\n  %s\n", post.PostedAt.UTC().Format("2006-01-02 15:04"), post.ID, target, strings.ReplaceAll(post.Text, "\n", "\n  "))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// runTimeline prints every event of a capsule, oldest first
+func runTimeline(args []string) int {
+	flags := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: memento timeline <capsule-id>")
+		return 2
+	}
+	capsuleID, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid capsule ID %q\n", flags.Arg(0))
+		return 2
+	}
+
+	db, store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	events, err := store.Timeline(capsuleID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get timeline: %v\n", err)
+		return 1
+	}
+	if len(events) == 0 {
+		fmt.Printf("No events for capsule #%d\n", capsuleID)
+		return 0
+	}
+
+	for _, e := range events {
+		printEvent(&e)
+	}
+	return 0
+}
+
+func printEvent(e *storage.CapsuleEvent) {
+	status := e.FromStatus
+	if e.ToStatus != e.FromStatus {
+		status += " -> " + e.ToStatus
+	}
+	fmt.Printf("%s  %-16s %-32s by %s\n", e.CreatedAt.UTC().Format("2006-01-02 15:04:05"), e.Event, status, e.Actor)
+	if e.Reason != nil {
+		fmt.Printf("    reason: %s\n", *e.Reason)
+	}
+	if e.Error != nil {
+		fmt.Printf("    error:  %s\n", *e.Error)
+	}
+	if len(e.TweetIDs) > 0 {
+		fmt.Printf("    tweets: %s\n", strings.Join(e.TweetIDs, ", "))
+	}
+}
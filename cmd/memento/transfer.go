@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/transfer"
+)
+
+// filterFlags are the capsule filters shared by export and import
+type filterFlags struct {
+	status    *string
+	requester *string
+	from      *string
+	to        *string
+}
+
+func addFilterFlags(flags *flag.FlagSet) filterFlags {
+	return filterFlags{
+		status:    flags.String("status", "", "only capsules in these comma-separated statuses"),
+		requester: flags.String("requester", "", "only capsules of this requester ID or @handle"),
+		from:      flags.String("from", "", "only capsules created on or after this date (YYYY-MM-DD or RFC 3339)"),
+		to:        flags.String("to", "", "only capsules created before this date (YYYY-MM-DD or RFC 3339)"),
+	}
+}
+
+func (f filterFlags) filter() (storage.CapsuleFilter, error) {
+	filter := storage.CapsuleFilter{Requester: *f.requester}
+	if *f.status != "" {
+		for _, status := range strings.Split(*f.status, ",") {
+			if !storage.CapsuleStatus(status).Valid() {
+				return filter, fmt.Errorf("invalid -status: unknown status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, storage.CapsuleStatus(status))
+		}
+	}
+
+	var err error
+	if filter.From, err = parseDate(*f.from); err != nil {
+		return filter, fmt.Errorf("invalid -from: %w", err)
+	}
+	if filter.To, err = parseDate(*f.to); err != nil {
+		return filter, fmt.Errorf("invalid -to: %w", err)
+	}
+	return filter, nil
+}
+
+func parseDate(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("expected YYYY-MM-DD or RFC 3339, got %q", v)
+	}
+	return &t, nil
+}
+
+// formatOf picks the format from the flag, or from the file extension
+func formatOf(format string, path string) string {
+	if format != "" {
+		return format
+	}
+	if filepath.Ext(path) == ".csv" {
+		return transfer.FORMAT_CSV
+	}
+	return transfer.FORMAT_JSONL
+}
+
+// openStore opens and migrates the database named by DATABASE_URL or
+// DATABASE_PATH, with the encryption keys of MASTER_KEY
+func openStore() (*storage.DB, *storage.CapsuleStore, error) {
+	cfg, err := config.LoadStorage()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := storage.Open(cfg.DatabaseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	keys, err := storage.OpenKeyring(db, clock.System, cfg.MasterKey, cfg.MasterKeyPrevious)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+	return db, storage.NewCapsuleStore(db, clock.System, keys, cfg.ErasureKey), nil
+}
+
+// runExport writes the capsules, and in JSON Lines the bot state, to a file
+// or stdout
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl or csv (default from the -o extension, else jsonl)")
+	output := flags.String("o", "-", "file to write, - for stdout")
+	filters := addFilterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// Keep the logs out of an export written to stdout
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	db, store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := transfer.Export(store, w, transfer.ExportOptions{
+		Format:     formatOf(*format, *output),
+		Filter:     filter,
+		ExportedAt: clock.System.Now(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d capsules\n", count)
+	return 0
+}
+
+// runImport reads an export into the database, skipping capsules whose
+// tweet is already saved
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl or csv (default from the file extension, else jsonl)")
+	filters := addFilterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: memento import [-format jsonl|csv] [filters] <file | ->")
+		return 2
+	}
+	input := flags.Arg(0)
+
+	filter, err := filters.filter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", input, err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	db, store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	result, err := transfer.Import(store, r, transfer.ImportOptions{
+		Format: formatOf(*format, input),
+		Filter: filter,
+	})
+	if result != nil {
+		fmt.Fprintf(os.Stderr, "imported %d capsules, skipped %d already saved or erased, %d filtered out, set %d state keys, added %d erased tweets\n",
+			result.Imported, result.Skipped, result.Filtered, result.Values, result.Erased)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
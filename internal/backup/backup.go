@@ -0,0 +1,285 @@
+// Package backup takes compressed snapshots of the live SQLite database,
+// rotates them on a daily, weekly and monthly plan and restores them
+package backup
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+const (
+	archivePrefix = "memento-"
+	archiveSuffix = ".db.gz"
+	// archiveTime stamps archive names in UTC, so names sort by age
+	archiveTime = "20060102T150405Z"
+)
+
+// Retention is how many archives rotation keeps: the newest of each of the
+// last Daily days, Weekly ISO weeks and Monthly months that have one
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Archive is a compressed snapshot in a backup directory
+type Archive struct {
+	Path    string
+	TakenAt time.Time
+	Size    int64
+}
+
+// Create snapshots the database with VACUUM INTO, checks the snapshot's
+// integrity and compresses it into dir as memento-<time>.db.gz. The archive
+// only gets its final name once it is complete.
+func Create(db *storage.DB, dir string, now time.Time) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	takenAt := now.UTC().Truncate(time.Second)
+	name := archivePrefix + takenAt.Format(archiveTime) + archiveSuffix
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+
+	snapshot := filepath.Join(dir, "."+name+".snapshot")
+	os.Remove(snapshot) // left over by an interrupted backup
+	defer os.Remove(snapshot)
+
+	if err := db.VacuumInto(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	if err := storage.CheckIntegrity(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to verify snapshot: %w", err)
+	}
+
+	partial := filepath.Join(dir, "."+name+".partial")
+	defer os.Remove(partial)
+	if err := compress(snapshot, partial); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, fmt.Errorf("failed to store backup: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat backup: %w", err)
+	}
+
+	return &Archive{Path: path, TakenAt: takenAt, Size: info.Size()}, nil
+}
+
+// List returns the archives in dir, newest first. Other files are ignored.
+func List(dir string) ([]Archive, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var archives []Archive
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, archivePrefix) || !strings.HasSuffix(name, archiveSuffix) {
+			continue
+		}
+		takenAt, err := time.Parse(archiveTime, strings.TrimSuffix(strings.TrimPrefix(name, archivePrefix), archiveSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat backup %s: %w", name, err)
+		}
+		archives = append(archives, Archive{Path: filepath.Join(dir, name), TakenAt: takenAt, Size: info.Size()})
+	}
+
+	sort.Slice(archives, func(i, j int) bool { return archives[i].TakenAt.After(archives[j].TakenAt) })
+	return archives, nil
+}
+
+// Rotate deletes the archives in dir that the retention plan doesn't keep
+// and returns them. The newest archive is always kept.
+func Rotate(dir string, keep Retention) ([]Archive, error) {
+	archives, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := retained(archives, keep)
+	var removed []Archive
+	for _, archive := range archives {
+		if kept[archive.Path] {
+			continue
+		}
+		if err := os.Remove(archive.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove backup: %w", err)
+		}
+		removed = append(removed, archive)
+	}
+
+	return removed, nil
+}
+
+// retained picks the archives to keep from archives sorted newest first
+func retained(archives []Archive, keep Retention) map[string]bool {
+	kept := make(map[string]bool)
+	if len(archives) == 0 {
+		return kept
+	}
+	kept[archives[0].Path] = true
+
+	periods := []struct {
+		count int
+		key   func(t time.Time) string
+	}{
+		{keep.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{keep.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{keep.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, period := range periods {
+		seen := make(map[string]bool)
+		for _, archive := range archives {
+			key := period.key(archive.TakenAt)
+			if seen[key] {
+				continue
+			}
+			if len(seen) == period.count {
+				break
+			}
+			seen[key] = true
+			kept[archive.Path] = true
+		}
+	}
+
+	return kept
+}
+
+// Restore replaces the SQLite database at dbPath with the archive and
+// returns where the replaced database was kept. It refuses while a bot holds
+// the database, and checks the integrity of the archive before touching the
+// database. The replaced database and its WAL files are kept next to it with
+// a .pre-restore-<time> suffix, so an earlier restore is never overwritten,
+// and are put back if the archive can't be put in place.
+func Restore(archivePath string, dbPath string, now time.Time) (string, error) {
+	lock, err := storage.LockDatabase(dbPath, true)
+	if err != nil {
+		return "", fmt.Errorf("refusing to restore over a running bot: %w", err)
+	}
+	defer lock.Unlock()
+
+	restored := dbPath + ".restore"
+	defer os.Remove(restored)
+	if err := decompress(archivePath, restored); err != nil {
+		return "", fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	if err := storage.CheckIntegrity(restored); err != nil {
+		return "", fmt.Errorf("failed to verify backup: %w", err)
+	}
+
+	aside := dbPath + ".pre-restore-" + now.UTC().Format(archiveTime)
+	var moved []string
+	rollback := func(cause error) error {
+		errs := []error{cause}
+		for _, suffix := range slices.Backward(moved) {
+			if err := os.Rename(aside+suffix, dbPath+suffix); err != nil {
+				errs = append(errs, fmt.Errorf("failed to put back %s: %w", dbPath+suffix, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Lstat(aside + suffix); err == nil {
+			return "", rollback(fmt.Errorf("failed to set the current database aside: %s already exists", aside+suffix))
+		}
+		err := os.Rename(dbPath+suffix, aside+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", rollback(fmt.Errorf("failed to set the current database aside: %w", err))
+		}
+		moved = append(moved, suffix)
+	}
+
+	if err := os.Rename(restored, dbPath); err != nil {
+		return "", rollback(fmt.Errorf("failed to put the backup in place: %w", err))
+	}
+
+	slog.Info("database restored", "backup", archivePath, "database", dbPath, "previous", aside)
+	return aside, nil
+}
+
+func compress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func decompress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, zr); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
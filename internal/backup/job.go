@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// retryDelay is how long the job waits after a failed backup
+const retryDelay = 10 * time.Minute
+
+// Job backs the database up every BackupInterval while the bot runs and
+// rotates the backup directory after each backup
+type Job struct {
+	DB     *storage.DB
+	Config *config.Config
+	Clock  clock.Clock
+}
+
+// StartBackups takes a backup whenever the newest one is BackupInterval old,
+// right away if there is none, until ctx is done
+func (j *Job) StartBackups(ctx context.Context) {
+	wait := j.untilNext()
+	for {
+		timer := j.Clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+			if err := j.Run(); err != nil {
+				slog.Error("backup failed", "error", err)
+				wait = retryDelay
+				continue
+			}
+			wait = j.untilNext()
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Info("backups stopped")
+			return
+		}
+	}
+}
+
+// Run takes one backup and rotates the backup directory. Only a failed
+// backup is returned; rotation errors are logged.
+func (j *Job) Run() error {
+	archive, err := Create(j.DB, j.Config.BackupDir, j.Clock.Now())
+	if err != nil {
+		return err
+	}
+	slog.Info("backup taken", "path", archive.Path, "bytes", archive.Size)
+
+	removed, err := Rotate(j.Config.BackupDir, j.Retention())
+	for _, old := range removed {
+		slog.Info("backup rotated out", "path", old.Path)
+	}
+	if err != nil {
+		slog.Error("error rotating backups", "error", err)
+	}
+	return nil
+}
+
+// Retention is the rotation plan of the configuration
+func (j *Job) Retention() Retention {
+	return Retention{
+		Daily:   j.Config.BackupKeepDaily,
+		Weekly:  j.Config.BackupKeepWeekly,
+		Monthly: j.Config.BackupKeepMonthly,
+	}
+}
+
+func (j *Job) untilNext() time.Duration {
+	archives, err := List(j.Config.BackupDir)
+	if err != nil {
+		slog.Warn("error listing backups", "error", err)
+		return j.Config.BackupInterval
+	}
+	if len(archives) == 0 {
+		return 0
+	}
+	return max(archives[0].TakenAt.Add(j.Config.BackupInterval).Sub(j.Clock.Now()), 0)
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// maxAnniversaries bounds the search for an anniversary in anniversaryCount
+const maxAnniversaries = 1000
+
+// anniversary returns the time the given number of years after from. A
+// capture made on Feb 29 comes back on Feb 28 in common years and on Feb 29
+// again in leap years. In dev mode RepublishDelay stands for RepublishYears,
+// so a year is that share of it.
+func anniversary(cfg *config.Config, from time.Time, years int) time.Time {
+	if cfg.DevMode {
+		return from.Add(cfg.RepublishDelay / time.Duration(cfg.RepublishYears) * time.Duration(years))
+	}
+
+	year := from.Year() + years
+	day := from.Day()
+	if from.Month() == time.February && day == 29 && !isLeapYear(year) {
+		day = 28
+	}
+	return time.Date(year, from.Month(), day, from.Hour(), from.Minute(), from.Second(), from.Nanosecond(), from.Location())
+}
+
+// anniversaryCount returns the number of the anniversary of from closest to
+// at. Delivery windows move an anniversary by a few hours around its date,
+// so the closest one is the one being celebrated.
+func anniversaryCount(cfg *config.Config, from time.Time, at time.Time) int {
+	years := 1
+	for years < maxAnniversaries && anniversary(cfg, from, years).Before(at) {
+		years++
+	}
+
+	if years > 1 && at.Sub(anniversary(cfg, from, years-1)) < anniversary(cfg, from, years).Sub(at) {
+		years--
+	}
+	return years
+}
+
+// nextOccurrence returns when a recurring capsule comes back after its
+// current occurrence, or nil when the capsule is not recurring or the next
+// anniversary falls after its end.
+func nextOccurrence(cfg *config.Config, capsule storage.Capsule) *time.Time {
+	if !capsule.Recurring {
+		return nil
+	}
+
+	years := anniversaryCount(cfg, capsule.CreatedAt, capsule.RepublishAt)
+	next := deliveryTime(cfg, anniversary(cfg, capsule.CreatedAt, years+1), capsule.DeliveryTZ)
+	if capsule.RecurUntil != nil && next.After(*capsule.RecurUntil) {
+		return nil
+	}
+	return &next
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
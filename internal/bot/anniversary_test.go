@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jvsena42/memento/internal/config"
+)
+
+func TestAnniversary(t *testing.T) {
+	cfg := &config.Config{RepublishYears: 5}
+	leapDay := time.Date(2024, 2, 29, 15, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		from  time.Time
+		years int
+		want  time.Time
+	}{
+		{"same date", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), 5, time.Date(2031, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"Feb 29 in a common year", leapDay, 1, time.Date(2025, 2, 28, 15, 4, 5, 0, time.UTC)},
+		{"Feb 29 in a leap year", leapDay, 4, time.Date(2028, 2, 29, 15, 4, 5, 0, time.UTC)},
+		{"Feb 29 in a century common year", time.Date(2096, 2, 29, 0, 0, 0, 0, time.UTC), 4, time.Date(2100, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"Feb 29 in a century leap year", time.Date(1996, 2, 29, 0, 0, 0, 0, time.UTC), 4, time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := anniversary(cfg, c.from, c.years); !got.Equal(c.want) {
+				t.Errorf("anniversary(%v, %d) = %v, want %v", c.from, c.years, got, c.want)
+			}
+		})
+	}
+}
+
+func TestAnniversaryCount(t *testing.T) {
+	cfg := &config.Config{RepublishYears: 5}
+	leapDay := time.Date(2024, 2, 29, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"first anniversary on Feb 28", time.Date(2025, 2, 28, 9, 0, 0, 0, time.UTC), 1},
+		{"a few hours past Feb 28", time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC), 1},
+		{"fourth anniversary on Feb 29", time.Date(2028, 2, 29, 11, 0, 0, 0, time.UTC), 4},
+		{"fifth anniversary on Feb 28", time.Date(2029, 2, 28, 10, 0, 0, 0, time.UTC), 5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := anniversaryCount(cfg, leapDay, c.at); got != c.want {
+				t.Errorf("anniversaryCount at %v = %d, want %d", c.at, got, c.want)
+			}
+		})
+	}
+}
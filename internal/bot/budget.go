@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+const (
+	POST_KIND_REPLY     = "reply"
+	POST_KIND_REPUBLISH = "republish"
+)
+
+const (
+	budgetDay   = 24 * time.Hour
+	budgetMonth = 30 * budgetDay
+	// forecastHorizon bounds how far ahead overflow capsules are planned
+	forecastHorizon = 366
+)
+
+var ErrBudgetExhausted = errors.New("post budget exhausted")
+
+// Budget keeps the bot within the daily and monthly post limits of its API
+// tier. Replies and republishes share the same limits, but republishes leave
+// ReplyReserve posts of the daily limit to replies.
+type Budget struct {
+	Store        storage.Store
+	DailyLimit   int
+	MonthlyLimit int
+	ReplyReserve int
+	Clock        clock.Clock
+}
+
+// Post posts a tweet if the budget allows it and records it. A nil Budget
+// posts without limits.
+func (b *Budget) Post(ctx context.Context, client *twitter.Client, kind string, text string, quoteTweetID string, replyToID string) (*twitter.TweetResponse, error) {
+	if b != nil {
+		daily, monthly, err := b.used()
+		if err != nil {
+			return nil, err
+		}
+		if daily >= b.DailyLimit || monthly >= b.MonthlyLimit {
+			return nil, ErrBudgetExhausted
+		}
+	}
+
+	response, err := client.PostTweet(ctx, text, quoteTweetID, replyToID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dry-run posts never reach the API, so they don't use up the budget
+	if b != nil && client.DryRun == nil {
+		if err := b.Store.RecordPost(kind, response.Tweet.ID); err != nil {
+			slog.Error("failed to record post", "tweet_id", response.Tweet.ID, "error", err)
+		}
+	}
+
+	return response, nil
+}
+
+// CanRepublish reports whether there is room left for one more republish
+func (b *Budget) CanRepublish() (bool, error) {
+	if b == nil {
+		return true, nil
+	}
+
+	daily, monthly, err := b.used()
+	if err != nil {
+		return false, err
+	}
+	return daily < b.republishCapacity() && monthly < b.MonthlyLimit, nil
+}
+
+// PlanSlots returns n times at which overflow capsules can be republished.
+// Slots start once the rolling windows free up and are spread evenly over
+// each following day, filling only the capacity left by the capsules and
+// collections already due that day. Earlier slots come first, so capsules handed slots in the
+// order they fell due keep that order.
+func (b *Budget) PlanSlots(n int) ([]time.Time, error) {
+	from, err := b.nextFreeAt()
+	if err != nil {
+		return nil, err
+	}
+
+	forecast, err := b.Store.DailyLoadForecast(from, forecastHorizon)
+	if err != nil {
+		return nil, err
+	}
+
+	perDay := b.republishCapacity()
+	slots := make([]time.Time, 0, n)
+	for day := 0; len(slots) < n; day++ {
+		start := from.Add(time.Duration(day) * budgetDay)
+
+		free := perDay
+		if day < len(forecast) {
+			free -= forecast[day].Posts
+		}
+		if free <= 0 {
+			continue
+		}
+
+		spacing := budgetDay / time.Duration(free)
+		for k := 0; k < free && len(slots) < n; k++ {
+			slots = append(slots, start.Add(time.Duration(k)*spacing))
+		}
+	}
+
+	return slots, nil
+}
+
+// used returns how many posts were made in the last day and month
+func (b *Budget) used() (int, int, error) {
+	now := b.Clock.Now().UTC()
+
+	daily, err := b.Store.CountPostsSince(now.Add(-budgetDay))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count daily posts: %w", err)
+	}
+	monthly, err := b.Store.CountPostsSince(now.Add(-budgetMonth))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count monthly posts: %w", err)
+	}
+	return daily, monthly, nil
+}
+
+// nextFreeAt returns when the oldest post of each exhausted window falls out
+// of it, freeing room for new posts
+func (b *Budget) nextFreeAt() (time.Time, error) {
+	now := b.Clock.Now().UTC()
+	freeAt := now
+
+	daily, monthly, err := b.used()
+	if err != nil {
+		return now, err
+	}
+
+	windows := []struct {
+		used   int
+		limit  int
+		length time.Duration
+	}{
+		{daily, b.republishCapacity(), budgetDay},
+		{monthly, b.MonthlyLimit, budgetMonth},
+	}
+	for _, window := range windows {
+		if window.used < window.limit {
+			continue
+		}
+		oldest, err := b.Store.OldestPostSince(now.Add(-window.length))
+		if err != nil {
+			return now, err
+		}
+		if oldest != nil && oldest.Add(window.length).After(freeAt) {
+			freeAt = oldest.Add(window.length)
+		}
+	}
+
+	return freeAt, nil
+}
+
+// republishCapacity returns how many republishes fit in a day
+func (b *Budget) republishCapacity() int {
+	return max(b.DailyLimit-b.ReplyReserve, 1)
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// errEmptyCollection is returned by publishCollection for a collection
+// without any member left to post
+var errEmptyCollection = errors.New("collection has no members")
+
+// errNothingPosted is returned by publishCollection when every member of the
+// thread failed for good
+var errNothingPosted = errors.New("no member of the collection could be posted")
+
+// publishDueCollections republishes every due collection as one thread: an
+// opening post followed by one reply per member, quoting the member tweet or
+// repeating its snapshot when the tweet is gone. Collections that don't fit
+// in the post budget are deferred, failed ones are retried like capsules,
+// and a thread cut short resumes from where it stopped.
+func (s *Scheduler) publishDueCollections(ctx context.Context) {
+	collections, err := s.CapsuleStore.ClaimDueCollections(s.Config.InstanceID, s.Config.LeaseDuration)
+	if err != nil {
+		slog.Error("error fetching collections", "error", err)
+		return
+	}
+
+	for i, collection := range collections {
+		canRepublish, err := s.Budget.CanRepublish()
+		if err != nil {
+			// A collection left over stays pending and is claimed again on a later run
+			slog.Error("error checking post budget", "error", err)
+			return
+		}
+		if !canRepublish {
+			s.deferCollections(collections[i:])
+			return
+		}
+
+		err = s.publishCollection(ctx, collection)
+		if errors.Is(err, ErrBudgetExhausted) {
+			s.deferCollections(collections[i:])
+			return
+		}
+
+		if err != nil {
+			s.recordCollectionFailure(collection, err)
+		} else if err := s.CapsuleStore.UpdateCollectionStatus(collection.ID, s.Config.InstanceID, storage.COLLECTION_PUBLISHED); err != nil {
+			slog.Error("failed to update collection status", "collection_id", collection.ID, "error", err)
+		}
+
+		s.Clock.Sleep(2 * time.Second)
+	}
+}
+
+// deferCollections moves collections that don't fit in the post budget to
+// later slots, like deferOverflow does for capsules
+func (s *Scheduler) deferCollections(collections []storage.Collection) {
+	slots, err := s.Budget.PlanSlots(len(collections))
+	if err != nil {
+		slog.Error("error planning deferred collections", "error", err)
+		return
+	}
+
+	for i, collection := range collections {
+		if err := s.CapsuleStore.DeferCollection(collection.ID, s.Config.InstanceID, slots[i]); err != nil {
+			slog.Error("failed to defer collection", "collection_id", collection.ID, "error", err)
+		}
+	}
+
+	slog.Warn("post budget exhausted, deferred collections",
+		"count", len(collections),
+		"first_slot", slots[0],
+		"last_slot", slots[len(slots)-1],
+	)
+}
+
+// publishCollection posts the thread of a collection, or the rest of it when
+// an earlier run was cut short. Every member is recorded as soon as it's
+// posted, so a thread stopped by ErrBudgetExhausted or a retryable error
+// never posts a member twice. A member that fails for good is recorded as
+// failed and the thread goes on without it. It fails when no member could be
+// posted, so the collection isn't taken for published.
+func (s *Scheduler) publishCollection(ctx context.Context, collection storage.Collection) error {
+	owner := s.Config.InstanceID
+
+	members, err := s.CapsuleStore.GetCollectionMembers(collection.ID)
+	if err != nil {
+		return err
+	}
+
+	total := len(members)
+	postedMembers := collection.ThreadPosted
+	var replyToID string
+	if collection.ThreadTweetID != nil {
+		replyToID = *collection.ThreadTweetID
+		if collection.ThreadTotal != nil {
+			total = *collection.ThreadTotal
+		}
+	} else if len(members) == 0 {
+		return errEmptyCollection
+	}
+
+	if len(members) > 0 {
+		var ids []string
+		for _, member := range members {
+			ids = append(ids, member.TweetID)
+		}
+
+		response, err := s.Client.GetTweets(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("failed to look up members: %w", err)
+		}
+
+		// Maps every member still alive to its newest version
+		latestIDs := make(map[string]string)
+		for _, tweet := range response.Tweets {
+			latestIDs[tweet.ID] = tweet.LatestVersionID()
+		}
+
+		if collection.ThreadTweetID == nil {
+			elapsed := elapsedSince(collection.CreatedAt, s.Clock.Now(), elapsedLocation(s.Config, ""))
+			intro := msgCollectionIntro(elapsed, collection.OwnerHandle, collection.Name, total)
+
+			posted, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, intro, "", "")
+			if err != nil {
+				return fmt.Errorf("failed to post collection intro: %w", err)
+			}
+			replyToID = posted.Tweet.ID
+
+			if err := s.CapsuleStore.StartCollectionThread(collection.ID, owner, replyToID, total); err != nil {
+				return fmt.Errorf("failed to record collection intro: %w", err)
+			}
+		}
+
+		// Members before these were handled by an earlier run
+		first := total - len(members) + 1
+		for i, member := range members {
+			var text, quoteID string
+			outcome := storage.STATUS_PUBLISHED
+			if latestID, ok := latestIDs[member.TweetID]; ok {
+				text = msgCollectionMember(first+i, total)
+				quoteID = latestID
+			} else {
+				text = msgCollectionMemberDeleted(first+i, total, member.TweetText)
+				outcome = storage.STATUS_DELETED_PUBLISHED
+			}
+
+			posted, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, text, quoteID, replyToID)
+			if errors.Is(err, ErrBudgetExhausted) || (err != nil && twitter.IsRetryable(err)) {
+				return fmt.Errorf("failed to post collection member: %w", err)
+			}
+			if err != nil {
+				// The intro is out already; keep the thread going with the remaining members
+				slog.Error("error posting collection member", "collection_id", collection.ID, "capsule_id", member.ID, "error", err)
+				if err := s.CapsuleStore.RecordCollectionMember(collection.ID, owner, member.ID, storage.STATUS_FAILED, "", err.Error()); err != nil {
+					return fmt.Errorf("failed to record collection member: %w", err)
+				}
+				continue
+			}
+
+			replyToID = posted.Tweet.ID
+			postedMembers++
+			if err := s.CapsuleStore.RecordCollectionMember(collection.ID, owner, member.ID, outcome, posted.Tweet.ID, ""); err != nil {
+				return fmt.Errorf("failed to record collection member: %w", err)
+			}
+		}
+	}
+
+	if postedMembers == 0 {
+		return fmt.Errorf("%w: %d members", errNothingPosted, total)
+	}
+	return nil
+}
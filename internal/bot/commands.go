@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mentionCommand holds the options the requester asked for in the mention text
+type mentionCommand struct {
+	Thread     bool
+	Milestones []int // years after which the capsule comes back, empty for a single republish
+	Recurring  bool
+	Cancel     bool
+	Collection string // name of the collection the tweet is added to, without the #
+	TimeZone   string // delivery time zone as written by the requester
+}
+
+var (
+	saveThreadPattern = regexp.MustCompile(`(?i)\bsave\s+(?:this\s+|the\s+)?thread\b`)
+	everyYearPattern  = regexp.MustCompile(`(?i)\bevery\s+year\b`)
+	cancelPattern     = regexp.MustCompile(`(?i)^\s*(?:@\w+\s+)*cancel\b`) // only as the first word after the handles
+	collectionPattern = regexp.MustCompile(`(?i)\bsave\s+to\s+#(\w+)`)
+	timeZonePattern   = regexp.MustCompile(`(?i)\b(?:tz|timezone|time\s+zone)\s*[:=]?\s*((?:UTC|GMT)[+-]\d{1,2}(?::?\d{2})?|[A-Za-z_]+(?:/[A-Za-z0-9_+-]+)+|UTC)`)
+)
+
+// milestonePresets maps the preset words accepted in the mention to the
+// anniversaries they schedule
+var milestonePresets = map[string][]int{
+	"milestones": {1, 3, 5, 10},
+}
+
+func parseCommand(text string) mentionCommand {
+	cmd := mentionCommand{
+		Thread:    saveThreadPattern.MatchString(text),
+		Recurring: everyYearPattern.MatchString(text),
+		Cancel:    cancelPattern.MatchString(text),
+	}
+
+	if match := collectionPattern.FindStringSubmatch(text); match != nil {
+		cmd.Collection = strings.ToLower(match[1])
+	}
+
+	if match := timeZonePattern.FindStringSubmatch(text); match != nil {
+		cmd.TimeZone = match[1]
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if years, ok := milestonePresets[strings.Trim(word, ".,!?\"'")]; ok {
+			cmd.Milestones = years
+		}
+	}
+
+	return cmd
+}
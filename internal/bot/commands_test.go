@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		text string
+		want mentionCommand
+	}{
+		{"@MementoBot", mentionCommand{}},
+		{"@MementoBot save this thread", mentionCommand{Thread: true}},
+		{"@MementoBot every year please", mentionCommand{Recurring: true}},
+
+		{"@MementoBot cancel", mentionCommand{Cancel: true}},
+		{"@MementoBot @someone Cancel this", mentionCommand{Cancel: true}},
+		{"@MementoBot please cancel", mentionCommand{}},
+
+		{"@MementoBot tz America/Sao_Paulo", mentionCommand{TimeZone: "America/Sao_Paulo"}},
+		{"@MementoBot timezone: UTC-3", mentionCommand{TimeZone: "UTC-3"}},
+		{"@MementoBot time zone=GMT+05:30", mentionCommand{TimeZone: "GMT+05:30"}},
+		{"@MementoBot TZ utc", mentionCommand{TimeZone: "utc"}},
+		{"@MementoBot tz America/Argentina/Buenos_Aires every year", mentionCommand{Recurring: true, TimeZone: "America/Argentina/Buenos_Aires"}},
+
+		{"@MementoBot milestones", mentionCommand{Milestones: []int{1, 3, 5, 10}}},
+		{"@MementoBot Milestones!", mentionCommand{Milestones: []int{1, 3, 5, 10}}},
+		{"@MementoBot my milestonesplan", mentionCommand{}},
+
+		{"@MementoBot save to #Trip2026", mentionCommand{Collection: "trip2026"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			if got := parseCommand(c.text); fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", c.want) {
+				t.Errorf("parseCommand = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// latestVersion returns the newest version of an edited tweet. When the newest
+// version can't be fetched, the given version is returned as is.
+func (s *Scheduler) latestVersion(ctx context.Context, tweet twitter.Tweet) twitter.Tweet {
+	latestID := tweet.LatestVersionID()
+	if latestID == tweet.ID {
+		return tweet
+	}
+
+	response, err := s.Client.GetTweet(ctx, latestID)
+	if err != nil {
+		slog.Warn("failed to fetch latest tweet version", "tweet_id", tweet.ID, "latest_id", latestID, "error", err)
+		return tweet
+	}
+	return response.Tweet
+}
+
+// wasEdited reports whether the tweet text changed after the capsule was captured
+func wasEdited(capsule storage.Capsule, latest twitter.Tweet) bool {
+	return latest.ID != capsule.TweetID && strings.TrimSpace(latest.Text) != capsule.TweetText
+}
+
+// postOriginalText replies to the republish with the text the tweet had when
+// it was captured
+func (s *Scheduler) postOriginalText(ctx context.Context, capsule storage.Capsule, replyToID string) {
+	if _, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, msgOriginalText(capsule.TweetText), "", replyToID); err != nil {
+		slog.Error("error posting original text", "capsule_id", capsule.ID, "error", err)
+	}
+}
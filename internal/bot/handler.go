@@ -5,40 +5,159 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jvsena42/memento/internal/clock"
 	"github.com/jvsena42/memento/internal/config"
 	"github.com/jvsena42/memento/internal/storage"
 	"github.com/jvsena42/memento/internal/twitter"
-	"modernc.org/sqlite"
 )
 
 const LAST_MENTION_ID = "last_mention_id"
 
 type Handler struct {
 	Client       *twitter.Client
-	CapsuleStore *storage.CapsuleStore
+	CapsuleStore storage.Store
 	Config       *config.Config
+	Wakeup       chan<- struct{} // wakes the scheduler up after a capsule is created
+	Budget       *Budget
+	Clock        clock.Clock
 }
 
+// errQuotaReached is returned by saveTweet when the requester already used
+// their daily capsule quota.
+var errQuotaReached = errors.New("daily quota reached")
+
+// errAlreadySaved is returned by saveTweet when the target tweet is already
+// in a capsule
+var errAlreadySaved = errors.New("tweet already saved")
+
+// errUnknownTimeZone is returned by deliveryTimeZone when the time zone in
+// the mention could not be resolved.
+var errUnknownTimeZone = errors.New("unknown time zone")
+
 func (h *Handler) ProcessMention(ctx context.Context, mention twitter.Tweet, users []twitter.User) error {
 
 	if mention.AuthorID == h.Client.BotUserID {
 		return nil
 	}
 
-	var targetTweet *twitter.TweetResponse
-	var err error
+	requesterHandler := findUser(users, mention.AuthorID)
 
-	if mention.InReplyToUserID != nil {
-		targetTweet, err = h.Client.GetTweet(ctx, mention.ConversationID)
-	} else {
-		targetTweet, err = h.Client.GetTweet(ctx, mention.ID)
+	if requesterHandler == "" {
+		slog.Warn("requesterHandler not found", "mentionID", mention.ID, "authorID", mention.AuthorID)
+		return nil
+	}
+
+	cmd := parseCommand(mention.Text)
+
+	// Links to tweets in the mention take precedence over the tweet it replies to
+	targetIDs := linkedStatusIDs(mention)
+	if len(targetIDs) == 0 {
+		if mention.InReplyToUserID != nil {
+			targetIDs = []string{mention.ConversationID}
+		} else {
+			targetIDs = []string{mention.ID}
+		}
+	}
+
+	if cmd.Cancel {
+		return h.cancel(ctx, mention, targetIDs)
 	}
 
+	timeZone, err := h.deliveryTimeZone(ctx, mention, cmd)
+	if errors.Is(err, errUnknownTimeZone) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to fetch target tweet: %w", err)
+		return err
+	}
+
+	// A time zone on a root mention only sets the preference
+	if cmd.TimeZone != "" && len(targetIDs) == 1 && targetIDs[0] == mention.ID {
+		text := msgTimeZoneSet(formatTimeOfDay(h.Config.DeliveryWindowStart), formatTimeOfDay(h.Config.DeliveryWindowEnd), timeZone)
+		if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, text, "", mention.ID); err != nil {
+			slog.Warn("failed to reply with time zone confirmation", "error", err)
+		}
+		return nil
+	}
+
+	var capsules []*storage.Capsule
+	var errs []error
+	alreadySaved := 0
+	for _, targetID := range targetIDs {
+		capsule, err := h.saveTweet(ctx, mention, cmd, requesterHandler, targetID, timeZone)
+		if errors.Is(err, errQuotaReached) {
+			if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, msgComeBackTomorrow, "", mention.ID); err != nil {
+				slog.Warn("failed to reply 'come back tomorrow'", "error", err)
+			}
+			break
+		}
+		if errors.Is(err, errAlreadySaved) {
+			alreadySaved++
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", targetID, err))
+			continue
+		}
+		if capsule != nil {
+			capsules = append(capsules, capsule)
+		}
+	}
+
+	if len(capsules) > 0 {
+		date := capsules[0].RepublishAt.Format("02/Jan/2006")
+		text := msgSaved(date, requesterHandler)
+		if capsules[0].Recurring {
+			text = msgSavedRecurring(date, requesterHandler)
+		}
+		if milestones := capsules[0].Milestones; len(milestones) > 0 {
+			text = msgSavedMilestones(joinYears(milestones), date, requesterHandler)
+		}
+		if cmd.Collection != "" {
+			text = msgAddedToCollection(cmd.Collection, date, requesterHandler)
+		} else if len(capsules) > 1 {
+			// Each link can come back on its own date, so every distinct one is listed
+			var dates []string
+			for _, capsule := range capsules {
+				if d := capsule.RepublishAt.Format("02/Jan/2006"); !slices.Contains(dates, d) {
+					dates = append(dates, d)
+				}
+			}
+			text = msgSavedMany(len(capsules), joinList(dates), requesterHandler)
+		}
+		if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, text, "", mention.ID); err != nil {
+			slog.Warn("failed to reply with confirmation", "error", err)
+		}
+	} else if alreadySaved > 0 {
+		// One reply however many of the links were already saved
+		if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, msgAlreadySaved, "", mention.ID); err != nil {
+			slog.Warn("failed to reply 'already saved'", "error", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// saveTweet captures a single target tweet for the requester of the mention.
+// It returns a nil capsule when the tweet was skipped.
+func (h *Handler) saveTweet(ctx context.Context, mention twitter.Tweet, cmd mentionCommand, requesterHandler string, targetID string, timeZone string) (*storage.Capsule, error) {
+	targetTweet, err := h.Client.GetTweet(ctx, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch target tweet: %w", err)
+	}
+
+	// A thread is always captured from its root
+	rootID := targetTweet.Tweet.ConversationID
+	if cmd.Thread && rootID != "" && rootID != targetTweet.Tweet.ID {
+		targetTweet, err = h.Client.GetTweet(ctx, rootID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch thread root: %w", err)
+		}
 	}
 
 	var tweetUsers []twitter.User
@@ -49,73 +168,120 @@ func (h *Handler) ProcessMention(ctx context.Context, mention twitter.Tweet, use
 
 	if tweetAuthor == "" {
 		slog.Warn("tweetAuthor not found", "mentionID", mention.ID, "authorID", targetTweet.Tweet.AuthorID)
-		return nil
+		return nil, nil
 	}
 
-	requesterHandler := findUser(users, mention.AuthorID)
-
-	if requesterHandler == "" {
-		slog.Warn("requesterHandler not found", "mentionID", mention.ID, "authorID", mention.AuthorID)
-		return nil
+	// Tweets of users who asked to be forgotten are never captured again
+	erased, err := h.CapsuleStore.TweetErased(targetTweet.Tweet.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check tweet: %w", err)
+	}
+	if erased {
+		slog.Info("tweet was erased, skipping", "tweet_id", targetTweet.Tweet.ID)
+		return nil, nil
 	}
 
 	saved, err := h.CapsuleStore.TweetAlreadySaved(targetTweet.Tweet.ID)
 	if err != nil {
-		return fmt.Errorf("failed to check tweet: %w", err)
+		return nil, fmt.Errorf("failed to check tweet: %w", err)
 	}
 	if saved {
-		if _, err := h.Client.PostTweet(ctx, "This one's already saved! ⏳", "", mention.ID); err != nil {
-			slog.Warn("failed to reply 'already saved'", "error", err)
-		}
-		return nil
+		return nil, errAlreadySaved
 	}
 
-	saved, err = h.CapsuleStore.UserSavedToday(mention.AuthorID)
+	savedToday, err := h.CapsuleStore.CountSavedToday(mention.AuthorID)
 	if err != nil {
-		return fmt.Errorf("failed to check tweet: %w", err)
+		return nil, fmt.Errorf("failed to check daily quota: %w", err)
 	}
-	if saved {
-		if _, err := h.Client.PostTweet(ctx, "Come back tomorrow! 🕰️", "", mention.ID); err != nil {
-			slog.Warn("failed to reply 'come back tomorrow'", "error", err)
-		}
-		return nil
+	if savedToday >= h.Config.DailyQuota {
+		return nil, errQuotaReached
 	}
 
 	trimmedText := strings.TrimSpace(targetTweet.Tweet.Text)
 	if trimmedText == "" {
 		slog.Warn("tweet text is empty, skipping", "tweet_id", targetTweet.Tweet.ID)
-		return nil
+		return nil, nil
 	}
 
+	now := h.Clock.Now().UTC()
 	capsule := storage.Capsule{
 		RequesterID:     mention.AuthorID,
 		RequesterHandle: requesterHandler,
 		TweetID:         targetTweet.Tweet.ID,
 		TweetAuthor:     tweetAuthor,
+		TweetAuthorID:   targetTweet.Tweet.AuthorID,
+		TweetAuthorName: findDisplayName(tweetUsers, targetTweet.Tweet.AuthorID),
 		TweetText:       trimmedText,
 		IsReply:         mention.InReplyToUserID != nil,
-		RepublishAt:     time.Now().UTC().Add(h.Config.RepublishDelay),
+		CreatedAt:       now,
+		RepublishAt:     deliveryTime(h.Config, anniversary(h.Config, now, h.Config.RepublishYears), timeZone),
+		DeliveryTZ:      timeZone,
+	}
+
+	capsule.EditHistoryIDs = targetTweet.Tweet.EditHistoryTweetIDs
+	if controls := targetTweet.Tweet.EditControls; controls != nil {
+		editsRemaining := controls.EditsRemaining
+		capsule.EditsRemaining = &editsRemaining
+		if editableUntil, err := time.Parse(time.RFC3339, controls.EditableUntil); err == nil {
+			capsule.EditableUntil = &editableUntil
+		}
+	}
+
+	if cmd.Collection != "" {
+		collection, err := h.CapsuleStore.GetOrCreateCollection(mention.AuthorID, requesterHandler, cmd.Collection, capsule.RepublishAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get collection: %w", err)
+		}
+		capsule.CollectionID = &collection.ID
+		capsule.RepublishAt = collection.RevealAt
+	} else if cmd.Recurring {
+		recurUntil := anniversary(h.Config, now, h.Config.RecurringYears)
+		capsule.Recurring = true
+		capsule.RecurUntil = &recurUntil
+		capsule.RepublishAt = deliveryTime(h.Config, anniversary(h.Config, now, 1), timeZone)
+	} else {
+		for _, years := range cmd.Milestones {
+			capsule.Milestones = append(capsule.Milestones, storage.Milestone{
+				Years: years,
+				DueAt: deliveryTime(h.Config, anniversary(h.Config, now, years), timeZone),
+			})
+		}
+		if len(capsule.Milestones) > 0 {
+			capsule.RepublishAt = capsule.Milestones[0].DueAt
+		}
+	}
+
+	if cmd.Thread {
+		thread, err := h.collectThread(ctx, targetTweet.Tweet)
+		if err != nil {
+			return nil, err
+		}
+		if len(thread) > 1 {
+			capsule.IsThread = true
+			for i, tweet := range thread {
+				capsule.Parts = append(capsule.Parts, storage.CapsulePart{
+					Position:  i,
+					TweetID:   tweet.ID,
+					TweetText: strings.TrimSpace(tweet.Text),
+				})
+			}
+		}
 	}
 
 	err = h.CapsuleStore.Create(&capsule)
 
 	if err != nil {
-		var sqliteErr *sqlite.Error
-		if errors.As(err, &sqliteErr) && sqliteErr.Code() == 2067 { // 2067 = SQLITE_CONSTRAINT_UNIQUE
+		if errors.Is(err, storage.ErrDuplicate) {
 			slog.Debug("duplicate capsule, skipping", "tweet_id", capsule.TweetID)
-			return nil
+			return nil, nil
 		}
 
-		return fmt.Errorf("failed to create capsule: %w", err)
+		return nil, fmt.Errorf("failed to create capsule: %w", err)
 	}
 
-	date := capsule.RepublishAt.Format("02/Jan/2006")
-	if _, err := h.Client.PostTweet(ctx, fmt.Sprintf("📸 Saved! I'll bring this back on %s, @%s!", date, requesterHandler),
-		"", mention.ID); err != nil {
-		slog.Warn("failed to reply with confirmation", "error", err)
-	}
+	h.wakeScheduler()
 
-	return nil
+	return &capsule, nil
 }
 
 func (h *Handler) StartPoller(ctx context.Context) {
@@ -126,14 +292,14 @@ func (h *Handler) StartPoller(ctx context.Context) {
 		h.Client.SinceID = sinceID
 	}
 
-	ticker := time.NewTicker(h.Config.PollInterval)
+	ticker := h.Clock.NewTicker(h.Config.PollInterval)
 
 	defer ticker.Stop()
-	h.pollMentions(ctx)
+	h.PollMentions(ctx)
 	for {
 		select {
-		case <-ticker.C:
-			h.pollMentions(ctx)
+		case <-ticker.C():
+			h.PollMentions(ctx)
 		case <-ctx.Done():
 			slog.Info("poller stopped")
 			return
@@ -141,7 +307,8 @@ func (h *Handler) StartPoller(ctx context.Context) {
 	}
 }
 
-func (h *Handler) pollMentions(ctx context.Context) {
+// PollMentions fetches the mentions since the last one seen and processes them
+func (h *Handler) PollMentions(ctx context.Context) {
 	tweetsResponse, err := h.Client.GetMentions(ctx)
 
 	if err != nil {
@@ -175,6 +342,94 @@ func (h *Handler) pollMentions(ctx context.Context) {
 	}
 }
 
+// deliveryTimeZone returns the time zone the requester's capsules are
+// delivered in: the one given in the mention, which also becomes their
+// preference, or else their saved preference. An unknown time zone in the
+// mention is answered and returns errUnknownTimeZone.
+func (h *Handler) deliveryTimeZone(ctx context.Context, mention twitter.Tweet, cmd mentionCommand) (string, error) {
+	if cmd.TimeZone == "" {
+		timeZone, err := h.CapsuleStore.GetTimeZone(mention.AuthorID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get time zone preference: %w", err)
+		}
+		return timeZone, nil
+	}
+
+	_, timeZone, err := loadTimeZone(cmd.TimeZone)
+	if err != nil {
+		text := msgUnknownTimeZone(cmd.TimeZone)
+		if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, text, "", mention.ID); err != nil {
+			slog.Warn("failed to reply 'unknown time zone'", "error", err)
+		}
+		return "", errUnknownTimeZone
+	}
+
+	if err := h.CapsuleStore.SetTimeZone(mention.AuthorID, timeZone); err != nil {
+		return "", fmt.Errorf("failed to save time zone preference: %w", err)
+	}
+
+	return timeZone, nil
+}
+
+// wakeScheduler lets the scheduler know a capsule was created, so it can
+// recompute when to wake up next. It never blocks.
+func (h *Handler) wakeScheduler() {
+	select {
+	case h.Wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// cancel stops the requester's capsules for the target tweets. A bare
+// "cancel" that targets no other tweet stops all their recurring capsules.
+func (h *Handler) cancel(ctx context.Context, mention twitter.Tweet, targetIDs []string) error {
+	var cancelled int64
+	if len(targetIDs) == 1 && targetIDs[0] == mention.ID {
+		n, err := h.CapsuleStore.CancelRecurring(mention.AuthorID)
+		if err != nil {
+			return fmt.Errorf("failed to cancel recurring capsules: %w", err)
+		}
+		cancelled = n
+	} else {
+		for _, targetID := range targetIDs {
+			ok, err := h.CapsuleStore.Cancel(mention.AuthorID, targetID)
+			if err != nil {
+				return fmt.Errorf("failed to cancel capsule: %w", err)
+			}
+			if ok {
+				cancelled++
+			}
+		}
+	}
+
+	text := msgNothingToCancel
+	if cancelled > 0 {
+		text = msgCancelled
+	}
+	if _, err := h.Budget.Post(ctx, h.Client, POST_KIND_REPLY, text, "", mention.ID); err != nil {
+		slog.Warn("failed to reply to cancel", "error", err)
+	}
+
+	return nil
+}
+
+// joinYears formats the milestone years as "1, 3, 5 and 10"
+func joinYears(milestones []storage.Milestone) string {
+	var years []string
+	for _, m := range milestones {
+		years = append(years, strconv.Itoa(m.Years))
+	}
+	return joinList(years)
+}
+
+// joinList formats items as "a, b and c"
+func joinList(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
 func findUser(users []twitter.User, userID string) string {
 	for _, user := range users {
 		if user.ID == userID {
@@ -183,3 +438,12 @@ func findUser(users []twitter.User, userID string) string {
 	}
 	return ""
 }
+
+func findDisplayName(users []twitter.User, userID string) string {
+	for _, user := range users {
+		if user.ID == userID {
+			return user.Name
+		}
+	}
+	return ""
+}
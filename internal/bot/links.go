@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"regexp"
+
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// statusURLPattern matches links to a single tweet on x.com or twitter.com,
+// e.g. https://x.com/someone/status/123 or https://twitter.com/i/web/status/123
+var statusURLPattern = regexp.MustCompile(`^https?://(?:www\.|mobile\.)?(?:x|twitter)\.com/(?:i/web|[A-Za-z0-9_]+)/status(?:es)?/(\d+)`)
+
+// linkedStatusIDs returns the IDs of the tweets linked in the tweet text, in
+// the order they appear and without duplicates. The t.co links in the text
+// are resolved through the expanded URL of each entity.
+func linkedStatusIDs(tweet twitter.Tweet) []string {
+	if tweet.Entities == nil {
+		return nil
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, entity := range tweet.Entities.URLs {
+		link := entity.ExpandedURL
+		if link == "" {
+			link = entity.URL
+		}
+
+		match := statusURLPattern.FindStringSubmatch(link)
+		if match == nil {
+			continue
+		}
+
+		id := match[1]
+		if id == tweet.ID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+func TestLinkedStatusIDs(t *testing.T) {
+	cases := []struct {
+		name string
+		urls []twitter.URLEntity
+		want []string
+	}{
+		{"no entities", nil, nil},
+		{"single link", []twitter.URLEntity{
+			{URL: "https://t.co/a", ExpandedURL: "https://x.com/someone/status/123"},
+		}, []string{"123"}},
+		{"multiple links in order", []twitter.URLEntity{
+			{URL: "https://t.co/a", ExpandedURL: "https://twitter.com/someone/status/300"},
+			{URL: "https://t.co/b", ExpandedURL: "https://mobile.x.com/other/statuses/100?s=20"},
+			{URL: "https://t.co/c", ExpandedURL: "https://x.com/i/web/status/200"},
+		}, []string{"300", "100", "200"}},
+		{"duplicates dropped", []twitter.URLEntity{
+			{URL: "https://t.co/a", ExpandedURL: "https://x.com/someone/status/123"},
+			{URL: "https://t.co/b", ExpandedURL: "https://www.twitter.com/someone/status/123/photo/1"},
+			{URL: "https://t.co/c", ExpandedURL: "https://x.com/other/status/456"},
+		}, []string{"123", "456"}},
+		{"own ID and other links skipped", []twitter.URLEntity{
+			{URL: "https://t.co/a", ExpandedURL: "https://x.com/bot/status/999"},
+			{URL: "https://t.co/b", ExpandedURL: "https://example.com/someone/status/1"},
+			{URL: "https://t.co/c", ExpandedURL: "https://x.com/someone"},
+		}, nil},
+		{"short URL when not expanded", []twitter.URLEntity{
+			{URL: "https://x.com/someone/status/42"},
+		}, []string{"42"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tweet := twitter.Tweet{ID: "999"}
+			if c.urls != nil {
+				tweet.Entities = &twitter.Entities{URLs: c.urls}
+			}
+			if got := linkedStatusIDs(tweet); fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("linkedStatusIDs = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Message catalog: every text the bot posts is built here.
+
+const (
+	msgAlreadySaved     = "This one's already saved! ⏳"
+	msgComeBackTomorrow = "Come back tomorrow! 🕰️"
+	msgCancelled        = "🛑 Cancelled! I won't bring it back anymore."
+	msgNothingToCancel  = "Nothing to cancel here 🤷"
+)
+
+func msgSaved(date string, handle string) string {
+	return fmt.Sprintf("📸 Saved! I'll bring this back on %s, @%s!", date, handle)
+}
+
+// msgSavedMany confirms several links saved at once, with every date they
+// come back on, e.g. "05/Mar/2031 and 06/Mar/2031"
+func msgSavedMany(count int, dates string, handle string) string {
+	return fmt.Sprintf("📸 Saved %d tweets! I'll bring them back on %s, @%s!", count, dates, handle)
+}
+
+func msgSavedRecurring(date string, handle string) string {
+	return fmt.Sprintf("📸 Saved! I'll bring this back every year, starting on %s, @%s! Reply \"cancel\" to stop.", date, handle)
+}
+
+func msgSavedMilestones(years string, date string, handle string) string {
+	return fmt.Sprintf("📸 Saved! I'll bring this back %s years from now, starting on %s, @%s!", years, date, handle)
+}
+
+func msgAddedToCollection(name string, date string, handle string) string {
+	return fmt.Sprintf("📸 Added to #%s! The whole collection comes back on %s, @%s!", name, date, handle)
+}
+
+func msgTimeZoneSet(start string, end string, timeZone string) string {
+	return fmt.Sprintf("🌍 Got it! Your memories will come back between %s and %s, %s time.", start, end, timeZone)
+}
+
+func msgUnknownTimeZone(timeZone string) string {
+	return fmt.Sprintf("🤔 I don't know the time zone %q. Try a name like America/Sao_Paulo or an offset like UTC-3.", timeZone)
+}
+
+// msgRepublish introduces the quote of a memory, e.g. "🕰️ 5 years ago today... @you"
+func msgRepublish(elapsed string, handle string) string {
+	return fmt.Sprintf("🕰️ %s... @%s", elapsed, handle)
+}
+
+// msgRepublishDeleted repeats the snapshot of a memory whose tweet is gone.
+// goneElapsed tells when the tweet was first seen deleted, if known.
+func msgRepublishDeleted(handle string, elapsed string, goneElapsed string, snapshot string, tweetID string) string {
+	deleted := "has been deleted"
+	if goneElapsed != "" {
+		deleted = "was deleted " + goneElapsed
+	}
+	prefix := fmt.Sprintf("🕰️ @%s saved this memory %s, but the original tweet %s 🕊️\n\nIt said: \"", handle, elapsed, deleted)
+	suffix := "\"\n\nOriginal link: "
+	return quoteSnapshot(prefix, snapshot, suffix, URL_SHORTEN_LENGTH) + "https://x.com/i/status/" + tweetID
+}
+
+func msgDeletedThreadPart(position int, total int, snapshot string) string {
+	return quoteSnapshot(fmt.Sprintf("🕊️ Part %d/%d (deleted): \"", position, total), snapshot, "\"", 0)
+}
+
+func msgOriginalText(snapshot string) string {
+	return quoteSnapshot("✏️ This tweet was edited after it was saved. Originally said: \"", snapshot, "\"", 0)
+}
+
+func msgCollectionIntro(elapsed string, handle string, name string, count int) string {
+	memories := fmt.Sprintf("Here are its %d memories", count)
+	if count == 1 {
+		memories = "Here is its 1 memory"
+	}
+	return fmt.Sprintf("🕰️ %s, @%s started the #%s collection 📚 %s 🧵", capitalize(elapsed), handle, name, memories)
+}
+
+func msgCollectionMember(position int, total int) string {
+	return fmt.Sprintf("%d/%d", position, total)
+}
+
+func msgCollectionMemberDeleted(position int, total int, snapshot string) string {
+	prefix := fmt.Sprintf("%s 🕊️ This one has been deleted. It said: \"", msgCollectionMember(position, total))
+	return quoteSnapshot(prefix, snapshot, "\"", 0)
+}
+
+// quoteSnapshot wraps a snapshot between prefix and suffix, truncating it so
+// the whole text plus reserved characters fits in a tweet
+func quoteSnapshot(prefix string, snapshot string, suffix string, reserved int) string {
+	availableChars := MAX_TWEET_LENGTH - utf8.RuneCountInString(prefix) - utf8.RuneCountInString(suffix) - reserved
+	return prefix + truncate(snapshot, availableChars) + suffix
+}
+
+// elapsedSince describes the time between from and to the way a person
+// would, e.g. "5 years ago today", "5 years and 3 days ago" or "a decade
+// ago". Calendar differences are taken in loc.
+func elapsedSince(from time.Time, to time.Time, loc *time.Location) string {
+	from, to = from.In(loc), to.In(loc)
+	years, months, days := calendarDiff(from, to)
+
+	switch {
+	case years > 0:
+		phrase := yearsPhrase(years)
+		switch {
+		case months == 0 && days == 0:
+			return phrase + " ago today"
+		case months == 0:
+			return fmt.Sprintf("%s and %s ago", phrase, plural(days, "day"))
+		default:
+			return fmt.Sprintf("%s and %s ago", phrase, plural(months, "month"))
+		}
+	case months > 0:
+		return plural(months, "month") + " ago"
+	case days > 0:
+		return plural(days, "day") + " ago"
+	}
+
+	// Less than a day, mostly in dev mode
+	elapsed := to.Sub(from)
+	switch {
+	case elapsed >= time.Hour:
+		return plural(int(elapsed.Hours()), "hour") + " ago"
+	case elapsed >= time.Minute:
+		return plural(int(elapsed.Minutes()), "minute") + " ago"
+	}
+	return "moments ago"
+}
+
+// calendarDiff returns the whole years, months and days between the dates
+// of from and to. The time of day is left out, since the delivery window
+// moves a republish by a few hours around its date. A Feb 29 capture counts
+// Feb 28 as its anniversary in common years.
+func calendarDiff(from time.Time, to time.Time) (int, int, int) {
+	if to.Before(from) {
+		return 0, 0, 0
+	}
+
+	fromDay := from.Day()
+	if from.Month() == time.February && fromDay == 29 && !isLeapYear(to.Year()) {
+		fromDay = 28
+	}
+
+	years := to.Year() - from.Year()
+	months := int(to.Month()) - int(from.Month())
+	days := to.Day() - fromDay
+
+	if days < 0 {
+		// Borrow the length of the month before to
+		days += time.Date(to.Year(), to.Month(), 0, 0, 0, 0, 0, to.Location()).Day()
+		months--
+	}
+	if months < 0 {
+		months += 12
+		years--
+	}
+
+	return years, months, days
+}
+
+func yearsPhrase(years int) string {
+	switch years {
+	case 1:
+		return "a year"
+	case 10:
+		return "a decade"
+	case 20:
+		return "two decades"
+	}
+	return fmt.Sprintf("%d years", years)
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if r >= 'a' && r <= 'z' {
+		return string(r-'a'+'A') + s[size:]
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCalendarDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want [3]int
+	}{
+		{"same day", date(2026, 3, 1), date(2026, 3, 1), [3]int{0, 0, 0}},
+		{"to before from", date(2026, 3, 2), date(2026, 3, 1), [3]int{0, 0, 0}},
+		{"whole years", date(2021, 3, 1), date(2026, 3, 1), [3]int{5, 0, 0}},
+		{"days borrowed from the month before", date(2021, 3, 30), date(2026, 4, 2), [3]int{5, 0, 3}},
+		{"months borrowed from the year before", date(2021, 11, 5), date(2026, 2, 5), [3]int{4, 3, 0}},
+		{"Feb 29 to Feb 28 of a common year", date(2024, 2, 29), date(2029, 2, 28), [3]int{5, 0, 0}},
+		{"Feb 29 to Feb 29 of a leap year", date(2024, 2, 29), date(2028, 2, 29), [3]int{4, 0, 0}},
+		{"Feb 29 to Mar 1 of a common year", date(2024, 2, 29), date(2025, 3, 1), [3]int{1, 0, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			years, months, days := calendarDiff(c.from, c.to)
+			if got := [3]int{years, months, days}; got != c.want {
+				t.Errorf("calendarDiff = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestElapsedSince(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatalf("loading time zone: %v", err)
+	}
+	saved := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		loc  *time.Location
+		want string
+	}{
+		{"anniversary", saved, saved.AddDate(5, 0, 0), time.UTC, "5 years ago today"},
+		{"a year", saved, saved.AddDate(1, 0, 0), time.UTC, "a year ago today"},
+		{"a decade", saved, saved.AddDate(10, 0, 0), time.UTC, "a decade ago today"},
+		{"two decades", saved, saved.AddDate(20, 0, 0), time.UTC, "two decades ago today"},
+		{"held back days", saved, saved.AddDate(5, 0, 3), time.UTC, "5 years and 3 days ago"},
+		{"held back a day", saved, saved.AddDate(10, 0, 1), time.UTC, "a decade and 1 day ago"},
+		{"held back months", saved, saved.AddDate(5, 2, 10), time.UTC, "5 years and 2 months ago"},
+		{"months", saved, saved.AddDate(0, 4, 0), time.UTC, "4 months ago"},
+		{"days", saved, saved.AddDate(0, 0, 1), time.UTC, "1 day ago"},
+		{"hours", saved, saved.Add(3 * time.Hour), time.UTC, "3 hours ago"},
+		{"minutes", saved, saved.Add(5 * time.Minute), time.UTC, "5 minutes ago"},
+		{"moments", saved, saved.Add(10 * time.Second), time.UTC, "moments ago"},
+		{"Feb 29 on Feb 28", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), time.Date(2029, 2, 28, 12, 0, 0, 0, time.UTC), time.UTC, "5 years ago today"},
+		// 01:00 UTC is still the day before in Sao Paulo
+		{"dates in the location", time.Date(2021, 3, 2, 1, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 13, 0, 0, 0, time.UTC), saoPaulo, "5 years ago today"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := elapsedSince(c.from, c.to, c.loc); got != c.want {
+				t.Errorf("elapsedSince = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestJoinList(t *testing.T) {
+	cases := []struct {
+		items []string
+		want  string
+	}{
+		{[]string{"05/Mar/2031"}, "05/Mar/2031"},
+		{[]string{"1", "3"}, "1 and 3"},
+		{[]string{"1", "3", "5", "10"}, "1, 3, 5 and 10"},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.items), func(t *testing.T) {
+			if got := joinList(c.items); got != c.want {
+				t.Errorf("joinList = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+// date returns midnight UTC of the given day
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
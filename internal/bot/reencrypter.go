@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// REENCRYPT_BATCH_SIZE is how many snapshots move onto the active data key
+// every ReencryptInterval
+const REENCRYPT_BATCH_SIZE = 100
+
+// Reencrypter rotates the data key once it is DataKeyRotateAfter old and
+// moves snapshots under retired keys, or still in plain text, onto the
+// active key a batch at a time
+type Reencrypter struct {
+	CapsuleStore storage.Store
+	Config       *config.Config
+	Clock        clock.Clock
+}
+
+// StartReencrypter runs a rotation check and a re-encryption batch every
+// ReencryptInterval until ctx is done. It does nothing without a master key.
+func (r *Reencrypter) StartReencrypter(ctx context.Context) {
+	if !r.CapsuleStore.EncryptionEnabled() {
+		slog.Info("encryption at rest disabled, set MASTER_KEY to enable it")
+		return
+	}
+
+	ticker := r.Clock.NewTicker(r.Config.ReencryptInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			r.RunOnce()
+		case <-ctx.Done():
+			slog.Info("reencrypter stopped")
+			return
+		}
+	}
+}
+
+// RunOnce rotates the data key if it is due and re-encrypts one batch
+func (r *Reencrypter) RunOnce() {
+	rotated, err := r.CapsuleStore.RotateDataKey(r.Config.DataKeyRotateAfter)
+	if err != nil {
+		slog.Error("error rotating data key", "error", err)
+		return
+	}
+	if rotated {
+		slog.Info("data key rotated, re-encrypting snapshots in the background")
+	}
+
+	count, err := r.CapsuleStore.ReencryptBatch(REENCRYPT_BATCH_SIZE)
+	if count > 0 {
+		slog.Info("snapshots re-encrypted", "count", count)
+	}
+	if err != nil {
+		slog.Error("error re-encrypting snapshots", "error", err)
+	}
+}
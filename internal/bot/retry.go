@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// maxRetryDelay caps the exponential backoff between publish attempts
+const maxRetryDelay = 24 * time.Hour
+
+// recordFailure stores the outcome of a failed publish attempt. Terminal
+// errors fail the capsule right away, retryable ones schedule another attempt
+// with exponential backoff until MaxPublishAttempts is reached, after which
+// the capsule is dead.
+func (s *Scheduler) recordFailure(capsule storage.Capsule, cause error) {
+	attempts := capsule.Attempts + 1
+
+	var err error
+	switch {
+	case !twitter.IsRetryable(cause):
+		slog.Error("error publishing capsule", "capsule_id", capsule.ID, "error", cause)
+		err = s.CapsuleStore.RecordFailure(capsule.ID, s.Config.InstanceID, storage.STATUS_FAILED, cause.Error())
+	case attempts >= s.Config.MaxPublishAttempts:
+		slog.Error("capsule ran out of publish attempts", "capsule_id", capsule.ID, "attempts", attempts, "error", cause)
+		err = s.CapsuleStore.RecordFailure(capsule.ID, s.Config.InstanceID, storage.STATUS_DEAD, cause.Error())
+	default:
+		nextAttemptAt := s.Clock.Now().UTC().Add(retryDelay(s.Config.RetryBaseDelay, attempts))
+		slog.Warn("error publishing capsule, will retry", "capsule_id", capsule.ID, "attempts", attempts, "next_attempt_at", nextAttemptAt, "error", cause)
+		err = s.CapsuleStore.ScheduleRetry(capsule.ID, s.Config.InstanceID, cause.Error(), nextAttemptAt)
+	}
+
+	if err != nil {
+		slog.Error("failed to record publish failure", "capsule_id", capsule.ID, "error", err)
+	}
+}
+
+// recordCollectionFailure stores the outcome of a failed publish attempt of
+// a collection, like recordFailure does for capsules. A collection without
+// anything left to post fails right away, since another attempt would find
+// the same.
+func (s *Scheduler) recordCollectionFailure(collection storage.Collection, cause error) {
+	attempts := collection.Attempts + 1
+
+	var err error
+	switch {
+	case errors.Is(cause, errEmptyCollection) || errors.Is(cause, errNothingPosted) || !twitter.IsRetryable(cause):
+		slog.Error("error publishing collection", "collection_id", collection.ID, "error", cause)
+		err = s.CapsuleStore.RecordCollectionFailure(collection.ID, s.Config.InstanceID, storage.COLLECTION_FAILED, cause.Error())
+	case attempts >= s.Config.MaxPublishAttempts:
+		slog.Error("collection ran out of publish attempts", "collection_id", collection.ID, "attempts", attempts, "error", cause)
+		err = s.CapsuleStore.RecordCollectionFailure(collection.ID, s.Config.InstanceID, storage.COLLECTION_DEAD, cause.Error())
+	default:
+		nextAttemptAt := s.Clock.Now().UTC().Add(retryDelay(s.Config.RetryBaseDelay, attempts))
+		slog.Warn("error publishing collection, will retry", "collection_id", collection.ID, "attempts", attempts, "next_attempt_at", nextAttemptAt, "error", cause)
+		err = s.CapsuleStore.ScheduleCollectionRetry(collection.ID, s.Config.InstanceID, cause.Error(), nextAttemptAt)
+	}
+
+	if err != nil {
+		slog.Error("failed to record collection publish failure", "collection_id", collection.ID, "error", err)
+	}
+}
+
+// retryDelay returns the backoff before the next attempt, doubling from base
+// with every failed attempt
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
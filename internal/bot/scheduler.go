@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"time"
 	"unicode/utf8"
 
+	"github.com/jvsena42/memento/internal/clock"
 	"github.com/jvsena42/memento/internal/config"
 	"github.com/jvsena42/memento/internal/storage"
 	"github.com/jvsena42/memento/internal/twitter"
@@ -16,16 +18,42 @@ import (
 const MAX_TWEET_LENGTH = 280
 const URL_SHORTEN_LENGTH = 23
 
+// minSchedulerWait keeps the scheduler from spinning on capsules that are
+// due but could not be published
+const minSchedulerWait = 1 * time.Second
+
+// errRepublishUnrecorded is returned when a republish was posted but the
+// capsule couldn't be moved on. The capsule keeps the posted tweet and is
+// completed on its next claim, so it must not be retried or failed.
+var errRepublishUnrecorded = errors.New("republish posted but not recorded")
+
 type Scheduler struct {
 	Client       *twitter.Client
-	CapsuleStore *storage.CapsuleStore
+	CapsuleStore storage.Store
 	Config       *config.Config
+	Wakeup       <-chan struct{} // signaled when a capsule is created
+	Budget       *Budget
+	Clock        clock.Clock
 }
 
+// PublishDueCapsules claims due capsules in batches and publishes them, then
+// does the same for due collections. Leases are renewed while the work is in
+// flight and released once it's done.
 func (s *Scheduler) PublishDueCapsules(ctx context.Context) {
+	owner := s.Config.InstanceID
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go s.renewLeases(renewCtx)
+
+	defer func() {
+		if err := s.CapsuleStore.ReleaseLeases(owner); err != nil {
+			slog.Error("failed to release leases", "error", err)
+		}
+	}()
 
 	for {
-		capsules, err := s.CapsuleStore.GetDueCapsules()
+		capsules, err := s.CapsuleStore.ClaimDueCapsules(owner, s.Config.LeaseDuration)
 
 		if err != nil {
 			slog.Error("error fetching capsules", "error", err)
@@ -36,91 +64,192 @@ func (s *Scheduler) PublishDueCapsules(ctx context.Context) {
 			break
 		}
 
-		for _, capsule := range capsules {
-			response, err := s.Client.GetTweet(ctx, capsule.TweetID)
+		// Capsules posted by an earlier attempt only need completing
+		capsules = slices.DeleteFunc(capsules, func(capsule storage.Capsule) bool {
+			if capsule.PostedTweetID == nil {
+				return false
+			}
+			s.resumeRepublish(capsule)
+			return true
+		})
 
-			if errors.Is(err, twitter.ErrForbidden) {
-				slog.Error("error publishing capsule", "error", err)
-				if err := s.CapsuleStore.UpdateStatus(capsule.ID, "failed"); err != nil {
-					slog.Error("failed to update capsule status", "capsule_id", capsule.ID, "error", err)
-				}
-				continue
+		for i, capsule := range capsules {
+			canRepublish, err := s.Budget.CanRepublish()
+			if err != nil {
+				slog.Error("error checking post budget", "error", err)
+				return
+			}
+			if !canRepublish {
+				s.deferOverflow(capsules[i:])
+				break
 			}
 
-			if errors.Is(err, twitter.ErrNotFound) {
+			if err := s.publishCapsule(ctx, capsule); errors.Is(err, ErrBudgetExhausted) {
+				s.deferOverflow(capsules[i:])
+				break
+			} else if errors.Is(err, errRepublishUnrecorded) {
+				slog.Error("failed to complete capsule, will complete it on the next claim", "capsule_id", capsule.ID, "error", err)
+			} else if err != nil {
+				s.recordFailure(capsule, err)
+			}
 
-				prefix := fmt.Sprintf("🕰️ @%s saved this memory 5 years ago, but the original tweet has been deleted 🕊️\n\nIt said: \"\"\n\nOriginal link: ", capsule.RequesterHandle)
-				prefixLength := utf8.RuneCountInString(prefix) + URL_SHORTEN_LENGTH
+			s.Clock.Sleep(2 * time.Second)
+		}
+	}
 
-				availableChars := MAX_TWEET_LENGTH - prefixLength
+	s.publishDueCollections(ctx)
+}
 
-				truncatedText := truncate(capsule.TweetText, availableChars)
+// deferOverflow moves capsules that don't fit in the post budget to later
+// slots. The capsules come in the order they fell due and keep that order.
+func (s *Scheduler) deferOverflow(capsules []storage.Capsule) {
+	slots, err := s.Budget.PlanSlots(len(capsules))
+	if err != nil {
+		slog.Error("error planning deferred capsules", "error", err)
+		return
+	}
 
-				text := fmt.Sprintf("🕰️ @%s saved this memory 5 years ago, but the original tweet has been deleted 🕊️\n\nIt said: \"%s\"\n\nOriginal link: https://x.com/i/status/%s",
-					capsule.RequesterHandle,
-					truncatedText,
-					capsule.TweetID,
-				)
+	for i, capsule := range capsules {
+		if err := s.CapsuleStore.DeferCapsule(capsule.ID, s.Config.InstanceID, slots[i]); err != nil {
+			slog.Error("failed to defer capsule", "capsule_id", capsule.ID, "error", err)
+		}
+	}
 
-				_, postErr := s.Client.PostTweet(ctx, text, "", "")
-				if postErr != nil {
-					slog.Error("error posting deleted capsule", "error", postErr)
-					if err := s.CapsuleStore.UpdateStatus(capsule.ID, "failed"); err != nil {
-						slog.Error("failed to update capsule status", "capsule_id", capsule.ID, "error", err)
-					}
-				} else {
-					if err := s.CapsuleStore.UpdateStatus(capsule.ID, "published"); err != nil {
-						slog.Error("failed to update capsule status", "capsule_id", capsule.ID, "error", err)
-					}
-				}
+	slog.Warn("post budget exhausted, deferred capsules",
+		"count", len(capsules),
+		"first_slot", slots[0],
+		"last_slot", slots[len(slots)-1],
+	)
+}
 
-				continue
-			}
+// renewLeases keeps the leases of this instance alive until ctx is done
+func (s *Scheduler) renewLeases(ctx context.Context) {
+	ticker := s.Clock.NewTicker(s.Config.LeaseDuration / 3)
+	defer ticker.Stop()
 
-			if err != nil {
-				slog.Error("error fetching tweet", "error", err)
-				continue
+	for {
+		select {
+		case <-ticker.C():
+			if err := s.CapsuleStore.RenewLeases(s.Config.InstanceID, s.Config.LeaseDuration); err != nil {
+				slog.Error("failed to renew leases", "error", err)
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			if response != nil { // Tweet exists
-				_, err := s.Client.PostTweet(ctx, fmt.Sprintf("🕰️ 5 years ago today... @%s", capsule.RequesterHandle), capsule.TweetID, "")
-				if err != nil {
-					slog.Error("error publishing tweet", "error", err)
-					if err := s.CapsuleStore.UpdateStatus(capsule.ID, "failed"); err != nil {
-						slog.Error("failed to update capsule status", "capsule_id", capsule.ID, "error", err)
-					}
-				} else {
-					if err := s.CapsuleStore.UpdateStatus(capsule.ID, "published"); err != nil {
-						slog.Error("failed to update capsule status", "capsule_id", capsule.ID, "error", err)
-					}
-				}
-			}
+// publishCapsule quotes the capsule tweet, or posts its snapshot when the
+// tweet has been deleted
+func (s *Scheduler) publishCapsule(ctx context.Context, capsule storage.Capsule) error {
+	now := s.Clock.Now()
+	loc := elapsedLocation(s.Config, capsule.DeliveryTZ)
+	elapsed := elapsedSince(capsule.CreatedAt, now, loc)
+	response, err := s.Client.GetTweet(ctx, capsule.TweetID)
 
-			time.Sleep(2 * time.Second)
+	if errors.Is(err, twitter.ErrNotFound) {
+		var goneElapsed string
+		if capsule.GoneAt != nil {
+			goneElapsed = elapsedSince(*capsule.GoneAt, now, loc)
 		}
+		text := msgRepublishDeleted(capsule.RequesterHandle, elapsed, goneElapsed, capsule.TweetText, capsule.TweetID)
+		posted, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, text, "", "")
+		if err != nil {
+			return fmt.Errorf("error posting deleted capsule: %w", err)
+		}
+
+		err = s.completeRepublish(capsule, storage.STATUS_DELETED_PUBLISHED, posted.Tweet.ID)
+		if capsule.IsThread {
+			s.postDeletedParts(ctx, capsule, posted.Tweet.ID)
+		}
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("error fetching tweet: %w", err)
+	}
+
+	latest := s.latestVersion(ctx, response.Tweet)
+	posted, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, msgRepublish(elapsed, capsule.RequesterHandle), latest.ID, "")
+	if err != nil {
+		return fmt.Errorf("error publishing tweet: %w", err)
 	}
+
+	err = s.completeRepublish(capsule, storage.STATUS_PUBLISHED, posted.Tweet.ID)
+	if capsule.IsThread {
+		s.postDeletedParts(ctx, capsule, posted.Tweet.ID)
+	}
+	if wasEdited(capsule, latest) {
+		s.postOriginalText(ctx, capsule, posted.Tweet.ID)
+	}
+	return err
 }
 
-func (s *Scheduler) StartScheduler(ctx context.Context) {
-	interval := 1 * time.Hour
-	if s.Config.DevMode {
-		interval = 1 * time.Minute
+// completeRepublish records the republish, with the outcome the capsule
+// ends in once nothing is left to republish. The posted tweet is recorded on
+// its own first, so a capsule that can't be completed stays publishing and
+// is never posted again.
+func (s *Scheduler) completeRepublish(capsule storage.Capsule, outcome storage.CapsuleStatus, postedTweetID string) error {
+	if err := s.CapsuleStore.RecordPosted(capsule.ID, s.Config.InstanceID, outcome, postedTweetID); err != nil {
+		slog.Error("failed to record posted republish", "capsule_id", capsule.ID, "error", err)
 	}
-	ticker := time.NewTicker(interval)
 
-	defer ticker.Stop()
+	if err := s.CapsuleStore.CompleteRepublish(capsule.ID, s.Config.InstanceID, outcome, postedTweetID, nextOccurrence(s.Config, capsule)); err != nil {
+		return fmt.Errorf("%w: %w", errRepublishUnrecorded, err)
+	}
+	return nil
+}
 
-	s.PublishDueCapsules(ctx)
+// resumeRepublish completes a capsule whose republish was posted by an
+// earlier attempt that couldn't complete it
+func (s *Scheduler) resumeRepublish(capsule storage.Capsule) {
+	outcome := storage.STATUS_PUBLISHED
+	if capsule.PostedOutcome != nil {
+		outcome = *capsule.PostedOutcome
+	}
+
+	slog.Info("completing capsule posted by an earlier attempt", "capsule_id", capsule.ID, "posted_tweet_id", *capsule.PostedTweetID)
+	if err := s.CapsuleStore.CompleteRepublish(capsule.ID, s.Config.InstanceID, outcome, *capsule.PostedTweetID, nextOccurrence(s.Config, capsule)); err != nil {
+		slog.Error("failed to complete capsule, will complete it on the next claim", "capsule_id", capsule.ID, "error", err)
+	}
+}
+
+// StartScheduler publishes due capsules until ctx is done. It sleeps until
+// the next republish time known to storage, wakes up early when Wakeup
+// signals a new capsule, and sweeps at least every SweepInterval.
+func (s *Scheduler) StartScheduler(ctx context.Context) {
+	timer := s.Clock.NewTimer(0)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C():
 			s.PublishDueCapsules(ctx)
+		case <-s.Wakeup:
 		case <-ctx.Done():
 			slog.Info("scheduler stopped")
 			return
 		}
+
+		timer.Reset(s.untilNextDue())
+	}
+}
+
+// untilNextDue returns how long to sleep before the next capsule is due,
+// capped by the safety sweep interval
+func (s *Scheduler) untilNextDue() time.Duration {
+	wait := s.Config.SweepInterval
+
+	next, err := s.CapsuleStore.NextRepublishAt()
+	if err != nil {
+		slog.Error("error fetching next republish time", "error", err)
+		return wait
+	}
+
+	if next != nil {
+		wait = min(wait, max(next.Sub(s.Clock.Now()), minSchedulerWait))
 	}
+	return wait
 }
 
 func truncate(s string, max int) string {
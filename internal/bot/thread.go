@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// collectThread walks the conversation started by root and returns the chain
+// of consecutive self-replies by the root author, starting with the root
+// itself. Replies from other users end the chain. Only replies from the last
+// seven days are visible to the recent search.
+func (h *Handler) collectThread(ctx context.Context, root twitter.Tweet) ([]twitter.Tweet, error) {
+	query := fmt.Sprintf("conversation_id:%s from:%s -is:retweet", root.ID, root.AuthorID)
+	response, err := h.Client.SearchRecent(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search conversation: %w", err)
+	}
+
+	replies := make(map[string][]twitter.Tweet)
+	for _, tweet := range response.Tweets {
+		if tweet.AuthorID != root.AuthorID {
+			continue
+		}
+		parentID := tweet.RepliedToID()
+		replies[parentID] = append(replies[parentID], tweet)
+	}
+
+	thread := []twitter.Tweet{root}
+	currentID := root.ID
+	for len(thread) <= len(response.Tweets) {
+		children := replies[currentID]
+		if len(children) == 0 {
+			break
+		}
+
+		// The author may have replied to the same tweet twice; the thread goes on with the first reply
+		next := children[0]
+		for _, child := range children[1:] {
+			if olderID(child.ID, next.ID) {
+				next = child
+			}
+		}
+
+		thread = append(thread, next)
+		currentID = next.ID
+	}
+
+	return thread, nil
+}
+
+// olderID reports whether tweet ID a was created before b. Tweet IDs are
+// snowflakes, so a shorter ID is always older.
+func olderID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// postDeletedParts replies to the republished root with the parts of the
+// thread that no longer exist, chained as a thread of their own.
+func (s *Scheduler) postDeletedParts(ctx context.Context, capsule storage.Capsule, replyToID string) {
+	parts, err := s.CapsuleStore.GetParts(capsule.ID)
+	if err != nil {
+		slog.Error("error fetching thread parts", "capsule_id", capsule.ID, "error", err)
+		return
+	}
+	if len(parts) < 2 {
+		return
+	}
+
+	var ids []string
+	for _, part := range parts[1:] {
+		ids = append(ids, part.TweetID)
+	}
+
+	response, err := s.Client.GetTweets(ctx, ids)
+	if err != nil {
+		slog.Error("error looking up thread parts", "capsule_id", capsule.ID, "error", err)
+		return
+	}
+
+	alive := make(map[string]bool)
+	for _, tweet := range response.Tweets {
+		alive[tweet.ID] = true
+	}
+
+	for _, part := range parts[1:] {
+		if alive[part.TweetID] {
+			continue
+		}
+
+		text := msgDeletedThreadPart(part.Position+1, len(parts), part.TweetText)
+		posted, err := s.Budget.Post(ctx, s.Client, POST_KIND_REPUBLISH, text, "", replyToID)
+		if err != nil {
+			slog.Error("error posting deleted thread part", "capsule_id", capsule.ID, "position", part.Position, "error", err)
+			return
+		}
+		replyToID = posted.Tweet.ID
+	}
+}
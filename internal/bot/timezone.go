@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvsena42/memento/internal/config"
+)
+
+// utcOffsetPattern matches fixed offsets such as UTC-3, UTC+05:30 or GMT+1
+var utcOffsetPattern = regexp.MustCompile(`^(?i)(?:UTC|GMT)([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// loadTimeZone resolves an IANA time zone name (America/Sao_Paulo) or a
+// fixed UTC offset (UTC-3) and returns it with its canonical name
+func loadTimeZone(name string) (*time.Location, string, error) {
+	if match := utcOffsetPattern.FindStringSubmatch(name); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		if hours > 14 || minutes > 59 {
+			return nil, "", fmt.Errorf("invalid UTC offset %q", name)
+		}
+
+		offset := hours*3600 + minutes*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		canonical := fmt.Sprintf("UTC%s%02d:%02d", match[1], hours, minutes)
+		return time.FixedZone(canonical, offset), canonical, nil
+	}
+
+	if strings.EqualFold(name, "UTC") {
+		return time.UTC, "UTC", nil
+	}
+
+	// IANA names are case sensitive and contain a slash
+	if !strings.Contains(name, "/") {
+		return nil, "", fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, loc.String(), nil
+}
+
+// alignToWindow moves t into the local delivery window of its date in the
+// given time zone. Times already inside the window are kept; others are
+// mapped proportionally into it, so capsules saved through the day stay
+// spread over the window instead of piling up at its start.
+func alignToWindow(t time.Time, loc *time.Location, start time.Duration, end time.Duration) time.Time {
+	local := t.In(loc)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+
+	if sinceMidnight >= start && sinceMidnight < end {
+		return t
+	}
+
+	offset := start + time.Duration(float64(end-start)*float64(sinceMidnight)/float64(24*time.Hour))
+	aligned := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, int(offset/time.Second), 0, loc)
+	return aligned.UTC()
+}
+
+// deliveryTime lines t up with the delivery window in the named time zone,
+// or in the default delivery time zone when the requester gave none. In dev
+// mode t is kept as is.
+func deliveryTime(cfg *config.Config, t time.Time, timeZone string) time.Time {
+	if cfg.DevMode {
+		return t
+	}
+	if timeZone == "" {
+		timeZone = cfg.DeliveryDefaultTZ
+	}
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+
+	loc, _, err := loadTimeZone(timeZone)
+	if err != nil {
+		slog.Warn("invalid delivery time zone, keeping exact time", "time_zone", timeZone, "error", err)
+		return t
+	}
+	return alignToWindow(t, loc, cfg.DeliveryWindowStart, cfg.DeliveryWindowEnd)
+}
+
+// elapsedLocation returns the location elapsed times are worded in: the
+// delivery time zone of the capsule, the default delivery time zone when it
+// has none, or UTC
+func elapsedLocation(cfg *config.Config, timeZone string) *time.Location {
+	if timeZone == "" {
+		timeZone = cfg.DeliveryDefaultTZ
+	}
+	if timeZone == "" {
+		return time.UTC
+	}
+	loc, _, err := loadTimeZone(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// formatTimeOfDay formats a duration since midnight as "15:04"
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// Reasons a saved tweet is no longer visible
+const (
+	GONE_REASON_DELETED   = "deleted"
+	GONE_REASON_PROTECTED = "protected"
+)
+
+// Verifier checks in the background that saved tweets are still alive while
+// their capsules wait, so the bot knows when a tweet disappeared
+type Verifier struct {
+	Client       *twitter.Client
+	CapsuleStore storage.Store
+	Config       *config.Config
+	Clock        clock.Clock
+}
+
+// StartVerifier checks a batch of saved tweets every VerifyInterval until
+// ctx is done. A batch size of zero turns the checks off.
+func (v *Verifier) StartVerifier(ctx context.Context) {
+	if v.Config.VerifyBatchSize == 0 {
+		slog.Info("liveness checks disabled")
+		return
+	}
+
+	ticker := v.Clock.NewTicker(v.Config.VerifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			v.VerifyBatch(ctx)
+		case <-ctx.Done():
+			slog.Info("verifier stopped")
+			return
+		}
+	}
+}
+
+// VerifyBatch looks up the least recently checked pending capsules in a
+// single request and records which tweets are alive and which are gone
+func (v *Verifier) VerifyBatch(ctx context.Context) {
+	now := v.Clock.Now().UTC()
+	capsules, err := v.CapsuleStore.CapsulesToVerify(v.Config.VerifyBatchSize, now.Add(-v.Config.VerifyRecheckAfter))
+	if err != nil {
+		slog.Error("error fetching capsules to verify", "error", err)
+		return
+	}
+	if len(capsules) == 0 {
+		return
+	}
+
+	var ids []string
+	for _, capsule := range capsules {
+		ids = append(ids, capsule.TweetID)
+	}
+
+	response, err := v.Client.GetTweets(ctx, ids)
+	if err != nil {
+		slog.Warn("error looking up saved tweets", "error", err)
+		return
+	}
+
+	alive := make(map[string]twitter.Tweet)
+	for _, tweet := range response.Tweets {
+		alive[tweet.ID] = tweet
+	}
+	gone := make(map[string]string)
+	for _, apiErr := range response.Errors {
+		switch apiErr.Type {
+		case twitter.ProblemResourceNotFound:
+			gone[apiErr.ResourceID] = GONE_REASON_DELETED
+		case twitter.ProblemNotAuthorized:
+			gone[apiErr.ResourceID] = GONE_REASON_PROTECTED
+		}
+	}
+
+	var users []twitter.User
+	if response.Includes != nil {
+		users = response.Includes.Users
+	}
+
+	for _, capsule := range capsules {
+		var err error
+		if tweet, ok := alive[capsule.TweetID]; ok {
+			err = v.CapsuleStore.RecordAlive(capsule.ID, findUser(users, tweet.AuthorID), findDisplayName(users, tweet.AuthorID), now)
+		} else if reason, ok := gone[capsule.TweetID]; ok {
+			if capsule.GoneReason == nil || *capsule.GoneReason != reason {
+				slog.Info("saved tweet is gone", "capsule_id", capsule.ID, "tweet_id", capsule.TweetID, "reason", reason)
+			}
+			err = v.CapsuleStore.RecordGone(capsule.ID, reason, now)
+		} else {
+			err = v.CapsuleStore.RecordChecked(capsule.ID, now)
+		}
+		if err != nil {
+			slog.Error("failed to record liveness", "capsule_id", capsule.ID, "error", err)
+		}
+	}
+
+	slog.Debug("verified saved tweets", "checked", len(capsules), "alive", len(alive), "gone", len(gone))
+}
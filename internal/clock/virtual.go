@@ -0,0 +1,151 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Virtual is a clock that only moves when told to. Timers and tickers fire
+// as the clock is moved past their deadline. Sleep moves the clock forward
+// instead of blocking, since whoever drives a virtual clock is also the one
+// waiting on it.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*virtualWaiter
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	v.Advance(d)
+}
+
+// Advance moves the clock forward by d
+func (v *Virtual) Advance(d time.Duration) {
+	v.Set(v.Now().Add(d))
+}
+
+// Set moves the clock to t, firing every timer and ticker due by then in
+// deadline order. Moving the clock backwards is ignored.
+func (v *Virtual) Set(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if t.Before(v.now) {
+		return
+	}
+
+	for {
+		sort.Slice(v.waiters, func(i, j int) bool {
+			return v.waiters[i].deadline.Before(v.waiters[j].deadline)
+		})
+		if len(v.waiters) == 0 || v.waiters[0].deadline.After(t) {
+			break
+		}
+
+		w := v.waiters[0]
+		v.now = w.deadline
+		w.fire(v.now)
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			v.waiters = v.waiters[1:]
+		}
+	}
+
+	v.now = t
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	w := &virtualWaiter{clock: v, c: make(chan time.Time, 1)}
+	w.Reset(d)
+	return w
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w := &virtualWaiter{clock: v, c: make(chan time.Time, 1), deadline: v.now.Add(d), period: d}
+	v.waiters = append(v.waiters, w)
+	return virtualTicker{w}
+}
+
+// virtualWaiter is a timer, or a ticker when it has a period
+type virtualWaiter struct {
+	clock    *Virtual
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+// fire delivers the time without blocking; like a real ticker, ticks are
+// dropped while the previous one hasn't been received
+func (w *virtualWaiter) fire(t time.Time) {
+	select {
+	case w.c <- t:
+	default:
+	}
+}
+
+func (w *virtualWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *virtualWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.remove()
+}
+
+func (w *virtualWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	active := w.remove()
+	w.deadline = w.clock.now.Add(d)
+	if d <= 0 {
+		w.fire(w.clock.now)
+		return active
+	}
+	w.clock.waiters = append(w.clock.waiters, w)
+	return active
+}
+
+// remove takes the waiter off the clock and reports whether it was pending.
+// The clock lock must be held.
+func (w *virtualWaiter) remove() bool {
+	for i, other := range w.clock.waiters {
+		if other == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type virtualTicker struct {
+	w *virtualWaiter
+}
+
+func (t virtualTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t virtualTicker) Stop() {
+	t.w.Stop()
+}
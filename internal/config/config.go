@@ -3,15 +3,43 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/jvsena42/memento/internal/envelope"
 )
 
 const (
 	defaultPollInterval  = 30 * time.Second
 	defaultRepublishDev  = 5 * time.Minute
-	defaultRepublishProd = 5 * 365 * 24 * time.Hour // ~5 years
+	defaultRepublishSpan = 5 // years
+	defaultDailyQuota    = 1
+	defaultRecurringEnd  = 10 // years
+	defaultSweepProd     = 1 * time.Hour
+	defaultSweepDev      = 1 * time.Minute
+	defaultMaxAttempts   = 8
+	defaultRetryBase     = 1 * time.Minute
+	defaultLease         = 5 * time.Minute
+	defaultPostsDaily    = 100
+	defaultPostsMonthly  = 3000
+	defaultReplyReserve  = 10
+	defaultWindowStart   = 9 * time.Hour
+	defaultWindowEnd     = 12 * time.Hour
+	defaultDeliveryTZ    = "UTC"
+	defaultVerifyProd    = 15 * time.Minute
+	defaultVerifyDev     = 1 * time.Minute
+	defaultVerifyBatch   = 100
+	defaultRecheckProd   = 7 * 24 * time.Hour
+	defaultRecheckDev    = 1 * time.Minute
+	defaultBackupEvery   = 24 * time.Hour
+	defaultKeepDaily     = 7
+	defaultKeepWeekly    = 4
+	defaultKeepMonthly   = 12
+	defaultKeyRotation   = 90 * 24 * time.Hour
+	defaultReencrypt     = 1 * time.Minute
+	minErasureKey        = 32
 )
 
 type Config struct {
@@ -21,10 +49,49 @@ type Config struct {
 	TwitterAccessSecret string
 	BotHandle           string
 	DatabasePath        string
+	DatabaseURL         string // DSN of the database, a postgres:// URL or a SQLite path
 	BotUserID           string
 	DevMode             bool
+	DryRun              bool // record tweets instead of posting them
 	PollInterval        time.Duration
-	RepublishDelay      time.Duration
+	RepublishDelay      time.Duration // dev mode only, stands for RepublishYears
+	RepublishYears      int
+	DailyQuota          int
+	RecurringYears      int
+	SweepInterval       time.Duration
+	MaxPublishAttempts  int
+	RetryBaseDelay      time.Duration
+	InstanceID          string
+	LeaseDuration       time.Duration
+	PostLimitDaily      int
+	PostLimitMonthly    int
+	PostReplyReserve    int
+	// Local time of day between which capsules come back, in the requester's
+	// time zone or else in DeliveryDefaultTZ
+	DeliveryWindowStart time.Duration
+	DeliveryWindowEnd   time.Duration
+	DeliveryDefaultTZ   string
+	// Background liveness checks: one lookup of up to VerifyBatchSize tweets
+	// every VerifyInterval, each tweet checked again after VerifyRecheckAfter
+	VerifyInterval     time.Duration
+	VerifyBatchSize    int
+	VerifyRecheckAfter time.Duration
+	// Scheduled snapshots of the SQLite database into BackupDir, off when it
+	// is empty, rotated to keep the newest of the last days, weeks and months
+	BackupDir         string
+	BackupInterval    time.Duration
+	BackupKeepDaily   int
+	BackupKeepWeekly  int
+	BackupKeepMonthly int
+	// Envelope encryption of tweet snapshots, off without a master key. The
+	// previous master key is only needed while rotating the master key.
+	MasterKey          *envelope.MasterKey
+	MasterKeyPrevious  *envelope.MasterKey
+	DataKeyRotateAfter time.Duration // 0 never rotates the data key
+	ReencryptInterval  time.Duration
+	// Secret keying the hashes that name users in erasure receipts, nil
+	// until set, which refuses erasures
+	ErasureKey []byte
 }
 
 func Load() (*Config, error) {
@@ -37,17 +104,22 @@ func Load() (*Config, error) {
 		TwitterAccessToken:  os.Getenv("TWITTER_ACCESS_TOKEN"),
 		TwitterAccessSecret: os.Getenv("TWITTER_ACCESS_SECRET"),
 		BotHandle:           os.Getenv("BOT_HANDLE"),
-		DatabasePath:        os.Getenv("DATABASE_PATH"),
 		BotUserID:           os.Getenv("BOT_USER_ID"),
 		DevMode:             os.Getenv("DEV_MODE") == "true",
+		DryRun:              os.Getenv("DRY_RUN") == "true",
+		InstanceID:          os.Getenv("INSTANCE_ID"),
 	}
 
 	if cfg.BotHandle == "" {
 		cfg.BotHandle = "MementoBot"
 	}
 
-	if cfg.DatabasePath == "" {
-		cfg.DatabasePath = "./memento.db"
+	cfg.DatabasePath, cfg.DatabaseURL = database()
+
+	// Identifies this instance as the owner of the capsules it claims
+	if cfg.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		cfg.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
 	}
 
 	// Poll interval
@@ -61,7 +133,177 @@ func Load() (*Config, error) {
 		cfg.PollInterval = defaultPollInterval
 	}
 
-	// Republish delay
+	// Daily capsule quota per requester
+	if v := os.Getenv("DAILY_CAPSULE_QUOTA"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid DAILY_CAPSULE_QUOTA %q: must be a positive integer", v)
+		}
+		cfg.DailyQuota = n
+	} else {
+		cfg.DailyQuota = defaultDailyQuota
+	}
+
+	// How many years after being saved a capsule comes back
+	if v := os.Getenv("REPUBLISH_YEARS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid REPUBLISH_YEARS %q: must be a positive integer", v)
+		}
+		cfg.RepublishYears = n
+	} else {
+		cfg.RepublishYears = defaultRepublishSpan
+	}
+
+	// How many years recurring capsules keep coming back
+	if v := os.Getenv("RECURRING_YEARS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid RECURRING_YEARS %q: must be a positive integer", v)
+		}
+		cfg.RecurringYears = n
+	} else {
+		cfg.RecurringYears = defaultRecurringEnd
+	}
+
+	// Safety sweep of the scheduler, on top of waking up at each due time
+	if v := os.Getenv("SWEEP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SWEEP_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.SweepInterval = d
+	} else if cfg.DevMode {
+		cfg.SweepInterval = defaultSweepDev
+	} else {
+		cfg.SweepInterval = defaultSweepProd
+	}
+
+	// Publish retries
+	if v := os.Getenv("MAX_PUBLISH_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid MAX_PUBLISH_ATTEMPTS %q: must be a positive integer", v)
+		}
+		cfg.MaxPublishAttempts = n
+	} else {
+		cfg.MaxPublishAttempts = defaultMaxAttempts
+	}
+
+	if v := os.Getenv("RETRY_BASE_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid RETRY_BASE_DELAY %q: must be a positive duration", v)
+		}
+		cfg.RetryBaseDelay = d
+	} else {
+		cfg.RetryBaseDelay = defaultRetryBase
+	}
+
+	// How long a claimed capsule stays leased without being renewed
+	if v := os.Getenv("LEASE_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LEASE_DURATION %q: must be a positive duration", v)
+		}
+		cfg.LeaseDuration = d
+	} else {
+		cfg.LeaseDuration = defaultLease
+	}
+
+	// Post limits of the API tier
+	limits := []struct {
+		name   string
+		target *int
+		def    int
+	}{
+		{"POST_LIMIT_DAILY", &cfg.PostLimitDaily, defaultPostsDaily},
+		{"POST_LIMIT_MONTHLY", &cfg.PostLimitMonthly, defaultPostsMonthly},
+		{"POST_REPLY_RESERVE", &cfg.PostReplyReserve, defaultReplyReserve},
+	}
+	for _, limit := range limits {
+		*limit.target = limit.def
+		if v := os.Getenv(limit.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", limit.name, v)
+			}
+			*limit.target = n
+		}
+	}
+
+	// Local delivery window
+	cfg.DeliveryWindowStart = defaultWindowStart
+	cfg.DeliveryWindowEnd = defaultWindowEnd
+	if v := os.Getenv("DELIVERY_WINDOW_START"); v != "" {
+		d, err := parseTimeOfDay(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DELIVERY_WINDOW_START %q: %w", v, err)
+		}
+		cfg.DeliveryWindowStart = d
+	}
+	if v := os.Getenv("DELIVERY_WINDOW_END"); v != "" {
+		d, err := parseTimeOfDay(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DELIVERY_WINDOW_END %q: %w", v, err)
+		}
+		cfg.DeliveryWindowEnd = d
+	}
+	if cfg.DeliveryWindowEnd <= cfg.DeliveryWindowStart {
+		return nil, fmt.Errorf("invalid delivery window: DELIVERY_WINDOW_END must be after DELIVERY_WINDOW_START")
+	}
+
+	// Time zone of the delivery window for requesters who gave none
+	cfg.DeliveryDefaultTZ = defaultDeliveryTZ
+	if v := os.Getenv("DELIVERY_DEFAULT_TZ"); v != "" {
+		if _, err := time.LoadLocation(v); err != nil || (v != "UTC" && !strings.Contains(v, "/")) {
+			return nil, fmt.Errorf("invalid DELIVERY_DEFAULT_TZ %q: must be UTC or an IANA time zone name", v)
+		}
+		cfg.DeliveryDefaultTZ = v
+	}
+
+	// Liveness checks of saved tweets
+	cfg.VerifyInterval = defaultVerifyProd
+	cfg.VerifyRecheckAfter = defaultRecheckProd
+	if cfg.DevMode {
+		cfg.VerifyInterval = defaultVerifyDev
+		cfg.VerifyRecheckAfter = defaultRecheckDev
+	}
+	if v := os.Getenv("VERIFY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid VERIFY_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.VerifyInterval = d
+	}
+	if v := os.Getenv("VERIFY_RECHECK_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid VERIFY_RECHECK_AFTER %q: must be a non-negative duration", v)
+		}
+		cfg.VerifyRecheckAfter = d
+	}
+	if v := os.Getenv("VERIFY_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("invalid VERIFY_BATCH_SIZE %q: must be between 0 and 100", v)
+		}
+		cfg.VerifyBatchSize = n
+	} else {
+		cfg.VerifyBatchSize = defaultVerifyBatch
+	}
+
+	if err := loadBackup(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadEncryption(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadErasure(cfg); err != nil {
+		return nil, err
+	}
+
+	// Republish delay, which stands for RepublishYears in dev mode
 
 	if cfg.DevMode {
 		if v := os.Getenv("REPUBLISH_DELAY"); v != "" {
@@ -73,8 +315,6 @@ func Load() (*Config, error) {
 		} else {
 			cfg.RepublishDelay = defaultRepublishDev
 		}
-	} else {
-		cfg.RepublishDelay = defaultRepublishProd
 	}
 
 	if err := cfg.validate(); err != nil {
@@ -84,6 +324,172 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// LoadStorage reads only the database, backup and encryption settings, for
+// the commands that need none of the bot credentials
+func LoadStorage() (*Config, error) {
+	_ = godotenv.Load()
+
+	cfg := &Config{}
+	cfg.DatabasePath, cfg.DatabaseURL = database()
+	if err := loadBackup(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadEncryption(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadErasure(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadBackup(cfg *Config) error {
+	cfg.BackupDir = os.Getenv("BACKUP_DIR")
+
+	cfg.BackupInterval = defaultBackupEvery
+	if v := os.Getenv("BACKUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid BACKUP_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.BackupInterval = d
+	}
+
+	keep := []struct {
+		name   string
+		target *int
+		def    int
+	}{
+		{"BACKUP_KEEP_DAILY", &cfg.BackupKeepDaily, defaultKeepDaily},
+		{"BACKUP_KEEP_WEEKLY", &cfg.BackupKeepWeekly, defaultKeepWeekly},
+		{"BACKUP_KEEP_MONTHLY", &cfg.BackupKeepMonthly, defaultKeepMonthly},
+	}
+	for _, k := range keep {
+		*k.target = k.def
+		if v := os.Getenv(k.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q: must be a non-negative integer", k.name, v)
+			}
+			*k.target = n
+		}
+	}
+
+	return nil
+}
+
+func loadEncryption(cfg *Config) error {
+	var err error
+	if cfg.MasterKey, err = masterKey("MASTER_KEY"); err != nil {
+		return err
+	}
+	if cfg.MasterKeyPrevious, err = masterKey("MASTER_KEY_PREVIOUS"); err != nil {
+		return err
+	}
+	if cfg.MasterKeyPrevious != nil && cfg.MasterKey == nil {
+		return fmt.Errorf("MASTER_KEY_PREVIOUS is set without MASTER_KEY")
+	}
+
+	cfg.DataKeyRotateAfter = defaultKeyRotation
+	if v := os.Getenv("DATA_KEY_ROTATE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid DATA_KEY_ROTATE_AFTER %q: must be a non-negative duration", v)
+		}
+		cfg.DataKeyRotateAfter = d
+	}
+
+	cfg.ReencryptInterval = defaultReencrypt
+	if v := os.Getenv("REENCRYPT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid REENCRYPT_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.ReencryptInterval = d
+	}
+
+	return nil
+}
+
+func loadErasure(cfg *Config) error {
+	key, err := secret("ERASURE_KEY")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+	if len(key) < minErasureKey {
+		return fmt.Errorf("invalid ERASURE_KEY: must be at least %d characters", minErasureKey)
+	}
+	cfg.ErasureKey = []byte(key)
+	return nil
+}
+
+// masterKey reads a master key from the variable name, or from the file that
+// name_FILE points to. It returns nil when neither is set.
+func masterKey(name string) (*envelope.MasterKey, error) {
+	encoded, err := secret(name)
+	if err != nil || encoded == "" {
+		return nil, err
+	}
+
+	key, err := envelope.ParseMasterKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// secret reads the variable name, or the file that name_FILE points to, such
+// as a mounted secret
+func secret(name string) (string, error) {
+	value := os.Getenv(name)
+	if file := os.Getenv(name + "_FILE"); file != "" {
+		if value != "" {
+			return "", fmt.Errorf("set either %s or %s_FILE, not both", name, name)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+		}
+		value = strings.TrimSpace(string(b))
+	}
+	return value, nil
+}
+
+// DatabaseURL returns the DSN of the database, for commands that need the
+// database but none of the bot credentials
+func DatabaseURL() string {
+	_ = godotenv.Load()
+	_, url := database()
+	return url
+}
+
+// database reads DATABASE_PATH and DATABASE_URL. Without a DSN the bot uses
+// the SQLite database at DATABASE_PATH.
+func database() (path string, url string) {
+	path = os.Getenv("DATABASE_PATH")
+	if path == "" {
+		path = "./memento.db"
+	}
+
+	url = os.Getenv("DATABASE_URL")
+	if url == "" {
+		url = path
+	}
+	return path, url
+}
+
+// parseTimeOfDay parses a "15:04" time of day into the duration since midnight
+func parseTimeOfDay(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
 func (c *Config) validate() error {
 	required := map[string]string{
 		"TWITTER_API_KEY":       c.TwitterAPIKey,
@@ -0,0 +1,118 @@
+// Package envelope implements envelope encryption with AES-256-GCM: data is
+// sealed with a data key, and data keys are wrapped by a master key that
+// never touches the database
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of master and data keys, for AES-256
+const KeySize = 32
+
+// ErrDecrypt is returned when a ciphertext can't be opened with a key,
+// because it was sealed with another key or was tampered with
+var ErrDecrypt = errors.New("decryption failed")
+
+// MasterKey wraps and unwraps data keys. Its ID is derived from the key, so
+// the database can record which master key wrapped a data key without
+// holding the key itself.
+type MasterKey struct {
+	ID  string
+	key []byte
+}
+
+// ParseMasterKey reads a 32-byte master key encoded in base64 or hex, as
+// produced by `openssl rand -base64 32` or `openssl rand -hex 32`
+func ParseMasterKey(encoded string) (*MasterKey, error) {
+	encoded = strings.TrimSpace(encoded)
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		key, err = hex.DecodeString(encoded)
+	}
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes in base64 or hex", KeySize)
+	}
+
+	return NewMasterKey(key), nil
+}
+
+// NewMasterKey uses a raw 32-byte key as master key
+func NewMasterKey(key []byte) *MasterKey {
+	sum := sha256.Sum256(append([]byte("memento master key "), key...))
+	return &MasterKey{ID: "mk-" + hex.EncodeToString(sum[:6]), key: key}
+}
+
+// NewDataKey returns a random data key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	return key, nil
+}
+
+// Wrap encrypts a data key under the master key. The data key ID is bound to
+// the wrapped key so wrapped keys can't be swapped between rows.
+func (m *MasterKey) Wrap(dataKeyID string, dataKey []byte) (string, error) {
+	return Seal(m.key, dataKey, dataKeyID)
+}
+
+// Unwrap decrypts a data key wrapped by Wrap
+func (m *MasterKey) Unwrap(dataKeyID string, wrapped string) ([]byte, error) {
+	return Open(m.key, wrapped, dataKeyID)
+}
+
+// Seal encrypts plaintext with AES-256-GCM under key and returns the random
+// nonce and ciphertext in base64. The associated data must be given again
+// to open it.
+func Seal(key []byte, plaintext []byte, associatedData string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(associatedData))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a ciphertext produced by Seal
+func Open(key []byte, ciphertext string, associatedData string) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(associatedData))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,281 @@
+package simulate
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+// recentSearchWindow is how far back the recent search endpoint reaches
+const recentSearchWindow = 7 * 24 * time.Hour
+
+var (
+	mentionsPath = regexp.MustCompile(`^/2/users/([^/]+)/mentions$`)
+	tweetPath    = regexp.MustCompile(`^/2/tweets/(\d+)$`)
+	searchQuery  = regexp.MustCompile(`conversation_id:(\d+) from:(\d+)`)
+)
+
+// Post is a tweet the bot posted to the fake API
+type Post struct {
+	ID           string
+	Text         string
+	QuoteTweetID string
+	ReplyToID    string
+	PostedAt     time.Time
+	Republish    bool // false for replies to mentions
+}
+
+// FakeAPI serves the subset of the Twitter API v2 the bot uses from memory.
+// Tweets get increasing numeric IDs and are stamped with the simulation clock.
+type FakeAPI struct {
+	Clock     clock.Clock
+	BotUserID string
+
+	mu       sync.Mutex
+	nextID   int64
+	users    map[string]twitter.User
+	tweets   map[string]twitter.Tweet
+	deleted  map[string]bool
+	mentions []string
+	posts    []Post
+}
+
+func NewFakeAPI(clk clock.Clock, botUserID string) *FakeAPI {
+	return &FakeAPI{
+		Clock:     clk,
+		BotUserID: botUserID,
+		nextID:    1000,
+		users:     make(map[string]twitter.User),
+		tweets:    make(map[string]twitter.Tweet),
+		deleted:   make(map[string]bool),
+	}
+}
+
+// AddUser registers an account that can author tweets
+func (f *FakeAPI) AddUser(user twitter.User) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.ID] = user
+}
+
+// AddTweet publishes a tweet now and returns it with its ID. Replies take
+// the conversation of the tweet they reply to; tweets that mention the bot
+// show up in its mentions timeline.
+func (f *FakeAPI) AddTweet(authorID string, text string, replyTo string) twitter.Tweet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tweet := twitter.Tweet{
+		ID:        f.newID(),
+		AuthorID:  authorID,
+		Text:      text,
+		CreatedAt: f.Clock.Now().UTC().Format(time.RFC3339),
+	}
+	tweet.ConversationID = tweet.ID
+	tweet.EditHistoryTweetIDs = []string{tweet.ID}
+
+	if parent, ok := f.tweets[replyTo]; ok {
+		tweet.ConversationID = parent.ConversationID
+		tweet.InReplyToUserID = &parent.AuthorID
+		tweet.ReferencedTweets = []twitter.ReferencedTweet{{Type: "replied_to", ID: parent.ID}}
+	}
+
+	f.tweets[tweet.ID] = tweet
+	if authorID != f.BotUserID && strings.Contains(text, "@"+f.botHandle()) {
+		f.mentions = append(f.mentions, tweet.ID)
+	}
+	return tweet
+}
+
+// DeleteTweet makes a tweet disappear from every endpoint
+func (f *FakeAPI) DeleteTweet(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted[id] = true
+}
+
+// Posts returns the tweets posted by the bot, oldest first
+func (f *FakeAPI) Posts() []Post {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Post(nil), f.posts...)
+}
+
+func (f *FakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/2/tweets":
+		f.postTweet(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/2/tweets":
+		f.lookupTweets(w, strings.Split(r.URL.Query().Get("ids"), ","))
+	case r.Method == http.MethodGet && r.URL.Path == "/2/tweets/search/recent":
+		f.searchRecent(w, r.URL.Query().Get("query"))
+	case r.Method == http.MethodGet && tweetPath.MatchString(r.URL.Path):
+		f.getTweet(w, tweetPath.FindStringSubmatch(r.URL.Path)[1])
+	case r.Method == http.MethodGet && mentionsPath.MatchString(r.URL.Path):
+		f.getMentions(w, r.URL.Query().Get("since_id"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeAPI) postTweet(w http.ResponseWriter, r *http.Request) {
+	var request twitter.PostTweetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tweet := twitter.Tweet{
+		ID:        f.newID(),
+		AuthorID:  f.BotUserID,
+		Text:      request.Text,
+		CreatedAt: f.Clock.Now().UTC().Format(time.RFC3339),
+	}
+	tweet.ConversationID = tweet.ID
+	post := Post{ID: tweet.ID, Text: request.Text, QuoteTweetID: request.QuoteTweetID, PostedAt: f.Clock.Now(), Republish: true}
+	if request.Reply != nil {
+		post.ReplyToID = request.Reply.InReplyToTweetID
+		if parent, ok := f.tweets[post.ReplyToID]; ok {
+			tweet.ConversationID = parent.ConversationID
+			// Threads of republishes are replies to the bot itself
+			post.Republish = parent.AuthorID == f.BotUserID
+		}
+	}
+
+	f.tweets[tweet.ID] = tweet
+	f.posts = append(f.posts, post)
+	writeJSON(w, twitter.TweetResponse{Tweet: tweet})
+}
+
+func (f *FakeAPI) getTweet(w http.ResponseWriter, id string) {
+	tweet, ok := f.visible(id)
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	writeJSON(w, twitter.TweetResponse{Tweet: tweet, Includes: f.includes([]twitter.Tweet{tweet})})
+}
+
+func (f *FakeAPI) lookupTweets(w http.ResponseWriter, ids []string) {
+	var response twitter.TweetsResponse
+	for _, id := range ids {
+		if tweet, ok := f.visible(id); ok {
+			response.Tweets = append(response.Tweets, tweet)
+			continue
+		}
+		response.Errors = append(response.Errors, twitter.APIError{
+			Title:      "Not Found Error",
+			Type:       twitter.ProblemResourceNotFound,
+			ResourceID: id,
+		})
+	}
+	response.Includes = f.includes(response.Tweets)
+	writeJSON(w, response)
+}
+
+func (f *FakeAPI) searchRecent(w http.ResponseWriter, query string) {
+	var response twitter.TweetsResponse
+	match := searchQuery.FindStringSubmatch(query)
+	if match == nil {
+		writeJSON(w, response)
+		return
+	}
+
+	since := f.Clock.Now().Add(-recentSearchWindow)
+	for _, id := range f.sortedIDs() {
+		tweet, ok := f.visible(id)
+		if !ok || tweet.ConversationID != match[1] || tweet.AuthorID != match[2] {
+			continue
+		}
+		if createdAt, err := time.Parse(time.RFC3339, tweet.CreatedAt); err == nil && createdAt.Before(since) {
+			continue
+		}
+		response.Tweets = append(response.Tweets, tweet)
+	}
+	response.Includes = f.includes(response.Tweets)
+	writeJSON(w, response)
+}
+
+// getMentions returns the mentions newer than sinceID, newest first, in a
+// single page
+func (f *FakeAPI) getMentions(w http.ResponseWriter, sinceID string) {
+	var response twitter.TweetsResponse
+	for i := len(f.mentions) - 1; i >= 0; i-- {
+		id := f.mentions[i]
+		if sinceID != "" && !newerID(id, sinceID) {
+			continue
+		}
+		if tweet, ok := f.visible(id); ok {
+			response.Tweets = append(response.Tweets, tweet)
+		}
+	}
+
+	if len(response.Tweets) > 0 {
+		response.Meta = &twitter.Meta{NewestID: response.Tweets[0].ID}
+	}
+	response.Includes = f.includes(response.Tweets)
+	writeJSON(w, response)
+}
+
+func (f *FakeAPI) visible(id string) (twitter.Tweet, bool) {
+	tweet, ok := f.tweets[id]
+	if !ok || f.deleted[id] {
+		return twitter.Tweet{}, false
+	}
+	return tweet, true
+}
+
+// includes expands the authors of the tweets
+func (f *FakeAPI) includes(tweets []twitter.Tweet) *twitter.Includes {
+	includes := &twitter.Includes{}
+	seen := make(map[string]bool)
+	for _, tweet := range tweets {
+		if user, ok := f.users[tweet.AuthorID]; ok && !seen[user.ID] {
+			seen[user.ID] = true
+			includes.Users = append(includes.Users, user)
+		}
+	}
+	return includes
+}
+
+func (f *FakeAPI) sortedIDs() []string {
+	ids := make([]string, 0, len(f.tweets))
+	for id := range f.tweets {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return newerID(ids[j], ids[i]) })
+	return ids
+}
+
+func (f *FakeAPI) botHandle() string {
+	return f.users[f.BotUserID].UserName
+}
+
+func (f *FakeAPI) newID() string {
+	f.nextID++
+	return strconv.FormatInt(f.nextID, 10)
+}
+
+// newerID reports whether tweet ID a was created after b
+func newerID(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
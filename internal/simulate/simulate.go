@@ -0,0 +1,292 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jvsena42/memento/internal/bot"
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/config"
+	"github.com/jvsena42/memento/internal/storage"
+	"github.com/jvsena42/memento/internal/twitter"
+)
+
+const (
+	botUserID = "1"
+	botHandle = "MementoBot"
+	// minStep keeps the simulation moving when something stays due, such as
+	// a capsule waiting for the post budget
+	minStep = time.Minute
+)
+
+// commands are the mention texts requesters pick from
+var commands = []string{
+	"save this one",
+	"save this one",
+	"save this one",
+	"save this every year",
+	"save this, milestones",
+	"save to #summer",
+	"save this tz America/Sao_Paulo",
+	"save this tz UTC+9",
+}
+
+// Options describes a simulation run
+type Options struct {
+	Start      time.Time
+	Years      int     // how long the simulation runs
+	Mentions   int     // mentions spread over the first year
+	Requesters int     // distinct users tagging the bot
+	DeleteRate float64 // share of saved tweets deleted before they come back
+	Seed       int64
+}
+
+// Report sums up what the bot posted during a simulation
+type Report struct {
+	Start    time.Time
+	End      time.Time
+	Mentions int
+	Deleted  int
+	Posts    []Post
+	Statuses map[string]int // capsules by final status
+}
+
+// event is something that happens on the fake network at a given time
+type event struct {
+	At      time.Time
+	Mention *mention
+	Delete  string // ID of a tweet deleted at this time
+}
+
+type mention struct {
+	RequesterID string
+	AuthorID    string
+	Command     string
+	DeleteAfter time.Duration // when the saved tweet gets deleted, zero to keep it
+}
+
+// Run plays a scripted scenario against the fake API on a virtual clock:
+// authors tweet, requesters tag the bot under those tweets and some tweets
+// get deleted. The clock jumps from one event or due capsule to the next,
+// running the mention handler, the scheduler and the verifier on the way.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	dir, err := os.MkdirTemp("", "memento-simulate-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create simulation directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := storage.New(filepath.Join(dir, "memento.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	clk := clock.NewVirtual(opts.Start)
+	cfg := simulationConfig()
+
+	api := NewFakeAPI(clk, botUserID)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client := &twitter.Client{
+		Authenticated: server.Client(),
+		BaseUrl:       server.URL,
+		BotUserID:     botUserID,
+		Clock:         clk,
+	}
+	capsuleStore := storage.NewCapsuleStore(db, clk, nil, nil)
+	budget := &bot.Budget{
+		Store:        capsuleStore,
+		DailyLimit:   cfg.PostLimitDaily,
+		MonthlyLimit: cfg.PostLimitMonthly,
+		ReplyReserve: cfg.PostReplyReserve,
+		Clock:        clk,
+	}
+	handler := bot.Handler{
+		Client:       client,
+		CapsuleStore: capsuleStore,
+		Config:       cfg,
+		Wakeup:       make(chan struct{}, 1),
+		Budget:       budget,
+		Clock:        clk,
+	}
+	scheduler := bot.Scheduler{
+		Client:       client,
+		CapsuleStore: capsuleStore,
+		Config:       cfg,
+		Budget:       budget,
+		Clock:        clk,
+	}
+	verifier := bot.Verifier{
+		Client:       client,
+		CapsuleStore: capsuleStore,
+		Config:       cfg,
+		Clock:        clk,
+	}
+
+	events := script(api, opts)
+	end := opts.Start.AddDate(opts.Years, 0, 0)
+	nextVerify := opts.Start.Add(cfg.VerifyInterval)
+	report := &Report{Start: opts.Start, End: end}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		now := clk.Now()
+		if !now.Before(end) {
+			break
+		}
+
+		next := minTime(end, nextVerify)
+		if len(events) > 0 {
+			next = minTime(next, events[0].At)
+		}
+		due, err := capsuleStore.NextRepublishAt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next republish time: %w", err)
+		}
+		if due != nil {
+			next = minTime(next, maxTime(*due, now.Add(minStep)))
+		}
+		clk.Set(next)
+
+		var mentioned bool
+		for len(events) > 0 && !events[0].At.After(next) {
+			e := events[0]
+			events = events[1:]
+			switch {
+			case e.Mention != nil:
+				target := api.AddTweet(e.Mention.AuthorID, "a moment worth remembering", "")
+				api.AddTweet(e.Mention.RequesterID, "@"+botHandle+" "+e.Mention.Command, target.ID)
+				report.Mentions++
+				mentioned = true
+				if e.Mention.DeleteAfter > 0 {
+					events = insertEvent(events, event{At: next.Add(e.Mention.DeleteAfter), Delete: target.ID})
+				}
+			case e.Delete != "":
+				api.DeleteTweet(e.Delete)
+				report.Deleted++
+			}
+		}
+
+		if mentioned {
+			handler.PollMentions(ctx)
+		}
+		scheduler.PublishDueCapsules(ctx)
+		if !clk.Now().Before(nextVerify) {
+			verifier.VerifyBatch(ctx)
+			nextVerify = nextVerify.Add(cfg.VerifyInterval)
+		}
+	}
+
+	report.Posts = api.Posts()
+	statuses, err := capsuleStore.CountByStatus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count capsules: %w", err)
+	}
+	report.Statuses = statuses
+
+	return report, nil
+}
+
+// script draws the mentions of the run from the seed, spread over the first
+// year and sorted by time. A DeleteRate share of the saved tweets is deleted
+// within five years of being saved.
+func script(api *FakeAPI, opts Options) []event {
+	random := rand.New(rand.NewSource(opts.Seed))
+
+	api.AddUser(twitter.User{ID: botUserID, Name: "Memento", UserName: botHandle})
+	requesters := make([]string, opts.Requesters)
+	for i := range requesters {
+		id := fmt.Sprintf("%d", 100+i)
+		api.AddUser(twitter.User{ID: id, Name: fmt.Sprintf("Requester %d", i+1), UserName: fmt.Sprintf("requester%d", i+1)})
+		requesters[i] = id
+	}
+	authors := make([]string, 10)
+	for i := range authors {
+		id := fmt.Sprintf("%d", 500+i)
+		api.AddUser(twitter.User{ID: id, Name: fmt.Sprintf("Author %d", i+1), UserName: fmt.Sprintf("author%d", i+1)})
+		authors[i] = id
+	}
+
+	year := opts.Start.AddDate(1, 0, 0).Sub(opts.Start)
+	events := make([]event, opts.Mentions)
+	for i := range events {
+		events[i] = event{
+			At: opts.Start.Add(time.Duration(random.Int63n(int64(year)))),
+			Mention: &mention{
+				RequesterID: requesters[random.Intn(len(requesters))],
+				AuthorID:    authors[random.Intn(len(authors))],
+				Command:     commands[random.Intn(len(commands))],
+			},
+		}
+		if random.Float64() < opts.DeleteRate {
+			events[i].Mention.DeleteAfter = time.Duration(1 + random.Int63n(int64(5*year)))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+
+	return events
+}
+
+func insertEvent(events []event, e event) []event {
+	i := sort.Search(len(events), func(i int) bool { return events[i].At.After(e.At) })
+	events = append(events, event{})
+	copy(events[i+1:], events[i:])
+	events[i] = e
+	return events
+}
+
+// simulationConfig is the production configuration, with liveness checks
+// once a day so years of virtual time go by quickly
+func simulationConfig() *config.Config {
+	return &config.Config{
+		BotHandle:           botHandle,
+		BotUserID:           botUserID,
+		InstanceID:          "simulation",
+		PollInterval:        30 * time.Second,
+		RepublishYears:      5,
+		DailyQuota:          1,
+		RecurringYears:      10,
+		SweepInterval:       time.Hour,
+		MaxPublishAttempts:  8,
+		RetryBaseDelay:      time.Minute,
+		LeaseDuration:       5 * time.Minute,
+		PostLimitDaily:      100,
+		PostLimitMonthly:    3000,
+		PostReplyReserve:    10,
+		DeliveryWindowStart: 9 * time.Hour,
+		DeliveryWindowEnd:   12 * time.Hour,
+		DeliveryDefaultTZ:   "UTC",
+		VerifyInterval:      24 * time.Hour,
+		VerifyBatchSize:     100,
+		VerifyRecheckAfter:  7 * 24 * time.Hour,
+	}
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
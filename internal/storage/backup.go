@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCorrupt is returned when a SQLite database fails its integrity check
+var ErrCorrupt = errors.New("database failed its integrity check")
+
+// VacuumInto writes a consistent, compacted copy of the SQLite database to
+// path while it stays online. The file must not exist yet. The copy is read
+// on a connection of its own, so writes carry on through the single writer
+// while it runs; the read pool can't take it, being query-only.
+func (db *DB) VacuumInto(path string) error {
+	if db.Dialect != SQLite {
+		return fmt.Errorf("snapshots need a SQLite database, not %s", db.Dialect)
+	}
+
+	// Only the writer sees an in-memory database
+	if db.path == "" {
+		if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+			return fmt.Errorf("vacuuming into %s: %w", path, err)
+		}
+		return nil
+	}
+
+	conn, err := sql.Open("sqlite", sqliteDSN(db.path, fmt.Sprintf("_pragma=busy_timeout(%d)", sqliteBusyTimeout.Milliseconds())))
+	if err != nil {
+		return fmt.Errorf("opening snapshot connection: %w", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("vacuuming into %s: %w", path, err)
+	}
+	return nil
+}
+
+// CheckIntegrity opens the SQLite database file at path read-only and runs
+// PRAGMA integrity_check on it
+func CheckIntegrity(path string) error {
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("checking integrity of %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return fmt.Errorf("scanning integrity check: %w", err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("checking integrity of %s: %w", path, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %w: %s", path, ErrCorrupt, strings.Join(problems, "; "))
+	}
+	return nil
+}
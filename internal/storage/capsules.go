@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
 )
 
 const capsuleBatchSize = 50
@@ -15,43 +18,195 @@ type Capsule struct {
 	RequesterHandle string
 	TweetID         string
 	TweetAuthor     string
+	TweetAuthorID   string // empty for capsules captured before it was recorded
+	TweetAuthorName string // display name of the author, refreshed while the tweet is alive
 	TweetText       string
 	IsReply         bool
+	IsThread        bool
+	Recurring       bool
+	RecurUntil      *time.Time
+	CollectionID    *int64
+	EditHistoryIDs  []string // versions of the tweet known at capture, oldest first
+	EditableUntil   *time.Time
+	EditsRemaining  *int
+	Attempts        int
+	LastError       *string
+	NextAttemptAt   *time.Time
+	DeliveryTZ      string // time zone of the delivery window, empty for the exact instant
+	LastCheckedAt   *time.Time
+	LastSeenAliveAt *time.Time
+	GoneAt          *time.Time // first time the tweet was seen deleted or protected
+	GoneReason      *string
+	Parts           []CapsulePart
+	Milestones      []Milestone
 	CreatedAt       time.Time
 	RepublishAt     time.Time
-	Status          string
+	Status          CapsuleStatus
 	PublishedAt     *time.Time
+	PostedTweetID   *string        // republish posted but not completed yet
+	PostedOutcome   *CapsuleStatus // outcome of that republish
+}
+
+// CapsulePart is one tweet of a captured thread
+type CapsulePart struct {
+	Position  int
+	TweetID   string
+	TweetText string
+}
+
+// capsuleColumns lists the columns read by scanCapsule, in order
+const capsuleColumns = `id, requester_id, requester_handle, tweet_id, tweet_author, tweet_author_id, tweet_author_name, tweet_text, is_reply, is_thread,
+	recurring, recur_until, collection_id, edit_history_ids, editable_until, edits_remaining,
+	attempts, last_error, next_attempt_at, delivery_tz, last_checked_at, last_seen_alive_at, gone_at, gone_reason,
+	created_at, republish_at, status, published_at, key_id, posted_tweet_id, posted_outcome`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanCapsule reads a row of capsuleColumns, decrypting the tweet snapshot
+func (s *CapsuleStore) scanCapsule(row scanner) (*Capsule, error) {
+	var c Capsule
+	var editHistoryIDs string
+	var keyID *string
+	err := row.Scan(&c.ID, &c.RequesterID, &c.RequesterHandle, &c.TweetID, &c.TweetAuthor, &c.TweetAuthorID, &c.TweetAuthorName, &c.TweetText, &c.IsReply, &c.IsThread,
+		&c.Recurring, &c.RecurUntil, &c.CollectionID, &editHistoryIDs, &c.EditableUntil, &c.EditsRemaining,
+		&c.Attempts, &c.LastError, &c.NextAttemptAt, &c.DeliveryTZ, &c.LastCheckedAt, &c.LastSeenAliveAt, &c.GoneAt, &c.GoneReason,
+		&c.CreatedAt, &c.RepublishAt, &c.Status, &c.PublishedAt, &keyID, &c.PostedTweetID, &c.PostedOutcome)
+	if err != nil {
+		return nil, err
+	}
+	if c.TweetText, err = s.keys.decrypt(c.TweetText, keyID); err != nil {
+		return nil, fmt.Errorf("capsule %d: %w", c.ID, err)
+	}
+	if editHistoryIDs != "" {
+		c.EditHistoryIDs = strings.Split(editHistoryIDs, ",")
+	}
+	return &c, nil
 }
 
 type CapsuleStore struct {
-	db *DB
+	db         *DB
+	clock      clock.Clock
+	keys       *Keyring // nil stores tweet snapshots in plain text
+	erasureKey []byte   // keys the hashes naming erased users, nil refuses erasures
 }
 
-func NewCapsuleStore(db *DB) *CapsuleStore {
-	return &CapsuleStore{db: db}
+func NewCapsuleStore(db *DB, clk clock.Clock, keys *Keyring, erasureKey []byte) *CapsuleStore {
+	return &CapsuleStore{db: db, clock: clk, keys: keys, erasureKey: erasureKey}
 }
 
+// Create inserts the capsule together with its thread parts and milestones, if any
 func (s *CapsuleStore) Create(c *Capsule) error {
-	result, err := s.db.Conn.Exec(`
-		INSERT INTO capsules (requester_id, requester_handle, tweet_id, tweet_author, tweet_text, is_reply, republish_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, c.RequesterID, c.RequesterHandle, c.TweetID, c.TweetAuthor, c.TweetText, c.IsReply, c.RepublishAt)
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = s.clock.Now().UTC()
+	}
+
+	text, keyID, err := s.keys.encrypt(c.TweetText)
 	if err != nil {
-		return fmt.Errorf("inserting capsule: %w", err)
+		return err
 	}
 
-	id, err := result.LastInsertId()
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("getting last insert id: %w", err)
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO capsules (requester_id, requester_handle, tweet_id, tweet_author, tweet_author_id, tweet_author_name, tweet_text, is_reply, is_thread, recurring, recur_until,
+			collection_id, edit_history_ids, editable_until, edits_remaining, delivery_tz, created_at, republish_at, key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, c.RequesterID, c.RequesterHandle, c.TweetID, c.TweetAuthor, c.TweetAuthorID, c.TweetAuthorName, text, c.IsReply, c.IsThread, c.Recurring, c.RecurUntil,
+		c.CollectionID, strings.Join(c.EditHistoryIDs, ","), c.EditableUntil, c.EditsRemaining, c.DeliveryTZ, c.CreatedAt, c.RepublishAt, keyID).Scan(&id)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("inserting capsule: %w", ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("inserting capsule: %w", err)
+	}
+
+	if err := s.insertParts(tx, id, c.Parts); err != nil {
+		return err
+	}
+
+	if err := s.recordEvents(tx, event{
+		kind:     EVENT_CREATED,
+		actor:    userActor(c.RequesterID),
+		tweetIDs: []string{c.TweetID},
+	}, "id = ?", id); err != nil {
+		return err
+	}
+
+	for _, milestone := range c.Milestones {
+		if _, err := tx.Exec(`
+			INSERT INTO capsule_milestones (capsule_id, years, due_at)
+			VALUES (?, ?, ?)
+		`, id, milestone.Years, milestone.DueAt); err != nil {
+			return fmt.Errorf("inserting capsule milestone: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing capsule: %w", err)
 	}
 	c.ID = id
 
 	return nil
 }
 
+// insertParts inserts the thread parts of a capsule, encrypted like the
+// capsule itself
+func (s *CapsuleStore) insertParts(tx *Tx, capsuleID int64, parts []CapsulePart) error {
+	for _, part := range parts {
+		text, keyID, err := s.keys.encrypt(part.TweetText)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO capsule_parts (capsule_id, position, tweet_id, tweet_text, key_id)
+			VALUES (?, ?, ?, ?, ?)
+		`, capsuleID, part.Position, part.TweetID, text, keyID); err != nil {
+			return fmt.Errorf("inserting capsule part: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetParts returns the thread parts of a capsule in reading order
+func (s *CapsuleStore) GetParts(capsuleID int64) ([]CapsulePart, error) {
+	rows, err := s.db.Query(`
+		SELECT position, tweet_id, tweet_text, key_id
+		FROM capsule_parts
+		WHERE capsule_id = ?
+		ORDER BY position ASC
+	`, capsuleID)
+	if err != nil {
+		return nil, fmt.Errorf("querying capsule parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []CapsulePart
+	for rows.Next() {
+		var p CapsulePart
+		var keyID *string
+		if err := rows.Scan(&p.Position, &p.TweetID, &p.TweetText, &keyID); err != nil {
+			return nil, fmt.Errorf("scanning capsule part: %w", err)
+		}
+		if p.TweetText, err = s.keys.decrypt(p.TweetText, keyID); err != nil {
+			return nil, fmt.Errorf("capsule %d part %d: %w", capsuleID, p.Position, err)
+		}
+		parts = append(parts, p)
+	}
+
+	return parts, rows.Err()
+}
+
 func (s *CapsuleStore) TweetAlreadySaved(TweetID string) (bool, error) {
 	var count int
-	err := s.db.Conn.QueryRow("SELECT COUNT(*) FROM capsules WHERE tweet_id = ?", TweetID).Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM capsules WHERE tweet_id = ?", TweetID).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("checking tweet existence: %w", err)
 	}
@@ -59,87 +214,192 @@ func (s *CapsuleStore) TweetAlreadySaved(TweetID string) (bool, error) {
 	return count > 0, nil
 }
 
-func (s *CapsuleStore) UserSavedToday(requesterID string) (bool, error) {
-	today := time.Now().UTC().Truncate(24 * time.Hour)
+// CountSavedToday returns how many capsules the requester created since
+// midnight UTC.
+func (s *CapsuleStore) CountSavedToday(requesterID string) (int, error) {
+	today := s.clock.Now().UTC().Truncate(24 * time.Hour)
 	tomorrow := today.Add(24 * time.Hour)
 
 	var count int
-	err := s.db.Conn.QueryRow(`
+	err := s.db.QueryRow(`
 		SELECT COUNT(*) FROM capsules
 		WHERE requester_id = ? AND created_at >= ? AND created_at < ?
 	`, requesterID, today, tomorrow).Scan(&count)
 	if err != nil {
-		return false, fmt.Errorf("checking daily rate limit: %w", err)
+		return 0, fmt.Errorf("checking daily rate limit: %w", err)
 	}
-	return count > 0, nil
+	return count, nil
 }
 
-// GetDueCapsules returns all pending capsules that are due for republishing
-func (s *CapsuleStore) GetDueCapsules() ([]Capsule, error) {
-	rows, err := s.db.Conn.Query(`
-		SELECT id, requester_id, requester_handle, tweet_id, tweet_author, tweet_text, is_reply, created_at, republish_at, status
-		FROM capsules
-		WHERE status = 'pending' AND republish_at <= ?
-		ORDER BY republish_at ASC
-		LIMIT ?
-	`,
-		time.Now().UTC(),
-		capsuleBatchSize,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("querying due capsules: %w", err)
-	}
-	defer rows.Close()
+// NextRepublishAt returns the earliest republish time among pending capsules
+// and collections that aren't leased, or nil when nothing is pending
+func (s *CapsuleStore) NextRepublishAt() (*time.Time, error) {
+	var next *time.Time
+	now := s.clock.Now().UTC()
 
-	var capsules []Capsule
-	for rows.Next() {
-		var c Capsule
-		if err := rows.Scan(&c.ID, &c.RequesterID, &c.RequesterHandle, &c.TweetID, &c.TweetAuthor, &c.TweetText, &c.IsReply, &c.CreatedAt, &c.RepublishAt, &c.Status); err != nil {
-			return nil, fmt.Errorf("scanning capsule: %w", err)
+	// A capsule waiting for a retry is due at next_attempt_at, which is always after republish_at
+	var republishAt time.Time
+	var nextAttemptAt *time.Time
+	err := s.db.QueryRow(`
+		SELECT republish_at, next_attempt_at FROM capsules
+		WHERE status = 'pending' AND collection_id IS NULL
+			AND (lease_expires_at IS NULL OR lease_expires_at <= ?)
+		ORDER BY COALESCE(next_attempt_at, republish_at) ASC
+		LIMIT 1
+	`, now).Scan(&republishAt, &nextAttemptAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("getting next republish time: %w", err)
+	}
+	if err == nil {
+		next = &republishAt
+		if nextAttemptAt != nil {
+			next = nextAttemptAt
 		}
-		capsules = append(capsules, c)
 	}
 
-	return capsules, rows.Err()
+	// A deferred collection is due at next_attempt_at, like a capsule
+	var revealAt time.Time
+	err = s.db.QueryRow(`
+		SELECT reveal_at, next_attempt_at FROM collections
+		WHERE status = 'pending' AND (lease_expires_at IS NULL OR lease_expires_at <= ?)
+		ORDER BY COALESCE(next_attempt_at, reveal_at) ASC
+		LIMIT 1
+	`, now).Scan(&revealAt, &nextAttemptAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("getting next reveal time: %w", err)
+	}
+	if err == nil && nextAttemptAt != nil {
+		revealAt = *nextAttemptAt
+	}
+	if err == nil && (next == nil || revealAt.Before(*next)) {
+		next = &revealAt
+	}
+
+	return next, nil
 }
 
-// UpdateStatus updates the status of a capsule and optionally sets published_at
-func (s *CapsuleStore) UpdateStatus(id int64, status string) error {
-	var err error
-	if status == "published" || status == "deleted" {
-		now := time.Now().UTC()
-		_, err = s.db.Conn.Exec(`
-			UPDATE capsules SET status = ?, published_at = ? WHERE id = ?
-		`, status, now, id)
+// UpdateStatus moves a capsule from one status to another, setting
+// published_at when it ends up published. It fails with ErrInvalidTransition
+// for a change the transition table doesn't allow, and with
+// ErrStatusConflict when the capsule is no longer in from. A publishing
+// capsule must still be leased to owner.
+func (s *CapsuleStore) UpdateStatus(id int64, from, to CapsuleStatus, owner string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	e := event{kind: EVENT_STATUS_CHANGED}
+	if to == STATUS_PUBLISHED || to == STATUS_DELETED_PUBLISHED {
+		err = s.setStatus(tx, id, from, to, owner, e, "published_at = ?", s.clock.Now().UTC())
 	} else {
-		_, err = s.db.Conn.Exec(`
-			UPDATE capsules SET status = ? WHERE id = ?
-		`, status, id)
+		err = s.setStatus(tx, id, from, to, owner, e, "")
 	}
 	if err != nil {
-		return fmt.Errorf("updating capsule status: %w", err)
+		return err
 	}
-	return nil
+	return tx.Commit()
+}
+
+// ScheduleRetry records a failed publish attempt of a capsule leased to owner
+// that should be retried at nextAttemptAt
+func (s *CapsuleStore) ScheduleRetry(id int64, owner string, lastError string, nextAttemptAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.setStatus(tx, id, STATUS_PUBLISHING, STATUS_PENDING, owner, event{
+		kind:   EVENT_RETRY_SCHEDULED,
+		reason: "next attempt at " + nextAttemptAt.UTC().Format(time.RFC3339),
+		err:    lastError,
+	}, "attempts = attempts + 1, last_error = ?, next_attempt_at = ?, lease_owner = NULL, lease_expires_at = NULL",
+		lastError, nextAttemptAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordFailure records a failed publish attempt of a capsule leased to owner
+// that won't be retried, leaving the capsule in the given status
+// (STATUS_FAILED or STATUS_DEAD)
+func (s *CapsuleStore) RecordFailure(id int64, owner string, status CapsuleStatus, lastError string) error {
+	if status != STATUS_FAILED && status != STATUS_DEAD {
+		return fmt.Errorf("%w: a failure can't leave a capsule %s", ErrInvalidTransition, status)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.setStatus(tx, id, STATUS_PUBLISHING, status, owner, event{kind: EVENT_FAILED, err: lastError},
+		"attempts = attempts + 1, last_error = ?, next_attempt_at = NULL, lease_owner = NULL, lease_expires_at = NULL",
+		lastError); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *CapsuleStore) GetByID(id int64) (*Capsule, error) {
-	var c Capsule
-	err := s.db.Conn.QueryRow(`
-		SELECT id, requester_id, requester_handle, tweet_id, tweet_author, tweet_text, is_reply, created_at, republish_at, status, published_at
+	c, err := s.scanCapsule(s.db.QueryRow(`
+		SELECT `+capsuleColumns+`
 		FROM capsules WHERE id = ?
-	`, id).Scan(&c.ID, &c.RequesterID, &c.RequesterHandle, &c.TweetID, &c.TweetAuthor, &c.TweetText, &c.IsReply, &c.CreatedAt, &c.RepublishAt, &c.Status, &c.PublishedAt)
+	`, id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("getting capsule by id: %w", err)
 	}
-	return &c, nil
+	return c, nil
+}
+
+// Cancel cancels the requester's pending capsule for the given tweet. It
+// reports whether there was such a capsule.
+func (s *CapsuleStore) Cancel(requesterID string, tweetID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cancelled, err := s.setStatuses(tx, STATUS_PENDING, STATUS_CANCELLED, event{
+		kind:  EVENT_CANCELLED,
+		actor: userActor(requesterID),
+	}, "requester_id = ? AND tweet_id = ?", []any{requesterID, tweetID}, "")
+	if err != nil {
+		return false, err
+	}
+	return cancelled > 0, tx.Commit()
+}
+
+// CancelRecurring cancels every pending recurring capsule of the requester
+// and returns how many were cancelled
+func (s *CapsuleStore) CancelRecurring(requesterID string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cancelled, err := s.setStatuses(tx, STATUS_PENDING, STATUS_CANCELLED, event{
+		kind:   EVENT_CANCELLED,
+		actor:  userActor(requesterID),
+		reason: "every recurring capsule cancelled",
+	}, "requester_id = ? AND recurring = TRUE", []any{requesterID}, "")
+	if err != nil {
+		return 0, err
+	}
+	return int64(cancelled), tx.Commit()
 }
 
 func (s *CapsuleStore) GetValue(key string) (string, error) {
 	var value string
-	err := s.db.Conn.QueryRow("SELECT value FROM key_value WHERE key = ?", key).Scan(&value)
+	err := s.db.QueryRow("SELECT value FROM key_value WHERE key = ?", key).Scan(&value)
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", nil
 	}
@@ -150,8 +410,32 @@ func (s *CapsuleStore) GetValue(key string) (string, error) {
 }
 
 func (s *CapsuleStore) SetValue(key string, value string) error {
-	if _, err := s.db.Conn.Exec("INSERT OR REPLACE INTO key_value (key, value) VALUES (?, ?)", key, value); err != nil {
+	if _, err := s.db.Exec(`
+		INSERT INTO key_value (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+	`, key, value); err != nil {
 		return fmt.Errorf("error setting value %s: %w", key, err)
 	}
 	return nil
 }
+
+// CountByStatus returns how many capsules are in each status
+func (s *CapsuleStore) CountByStatus() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM capsules GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("counting capsules by status: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("scanning capsule count: %w", err)
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
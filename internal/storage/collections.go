@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Collection is a named set of capsules owned by a requester. Its members
+// are republished together as one thread at RevealAt.
+type Collection struct {
+	ID          int64
+	OwnerID     string
+	OwnerHandle string
+	Name        string
+	CreatedAt   time.Time
+	RevealAt    time.Time
+	Status      CollectionStatus
+	PublishedAt *time.Time
+	// ThreadTweetID is the last post of a thread cut short, which the rest
+	// of the thread replies to
+	ThreadTweetID *string
+	ThreadTotal   *int // members announced by the intro of the thread
+	ThreadPosted  int  // members posted in the thread so far
+	Attempts      int  // failed publish attempts so far
+	LastError     *string
+	NextAttemptAt *time.Time
+}
+
+const collectionColumns = `id, owner_id, owner_handle, name, created_at, reveal_at, status, published_at,
+	thread_tweet_id, thread_total, thread_posted, attempts, last_error, next_attempt_at`
+
+func scanCollection(row scanner) (*Collection, error) {
+	var c Collection
+	if err := row.Scan(&c.ID, &c.OwnerID, &c.OwnerHandle, &c.Name, &c.CreatedAt, &c.RevealAt, &c.Status, &c.PublishedAt,
+		&c.ThreadTweetID, &c.ThreadTotal, &c.ThreadPosted, &c.Attempts, &c.LastError, &c.NextAttemptAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetOrCreateCollection returns the owner's open collection with the given
+// name, creating it with the given reveal date when there is none. Names are
+// case-insensitive.
+func (s *CapsuleStore) GetOrCreateCollection(ownerID string, ownerHandle string, name string, revealAt time.Time) (*Collection, error) {
+	name = strings.ToLower(name)
+
+	if _, err := s.db.Exec(`
+		INSERT INTO collections (owner_id, owner_handle, name, created_at, reveal_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (owner_id, name) WHERE status = 'pending' DO NOTHING
+	`, ownerID, ownerHandle, name, s.clock.Now().UTC(), revealAt); err != nil {
+		return nil, fmt.Errorf("inserting collection: %w", err)
+	}
+
+	c, err := scanCollection(s.db.QueryRow(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE owner_id = ? AND name = ? AND status = 'pending'
+	`, ownerID, name))
+	if err != nil {
+		return nil, fmt.Errorf("getting collection: %w", err)
+	}
+	return c, nil
+}
+
+func (s *CapsuleStore) GetCollectionByID(id int64) (*Collection, error) {
+	c, err := scanCollection(s.db.QueryRow(`
+		SELECT `+collectionColumns+`
+		FROM collections WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting collection by id: %w", err)
+	}
+	return c, nil
+}
+
+// GetCollectionMembers returns the pending capsules of a collection in the
+// order they were added
+func (s *CapsuleStore) GetCollectionMembers(collectionID int64) ([]Capsule, error) {
+	rows, err := s.db.Query(`
+		SELECT `+capsuleColumns+`
+		FROM capsules
+		WHERE collection_id = ? AND status = 'pending'
+		ORDER BY created_at ASC, id ASC
+	`, collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("querying collection members: %w", err)
+	}
+	defer rows.Close()
+
+	var capsules []Capsule
+	for rows.Next() {
+		c, err := s.scanCapsule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning capsule: %w", err)
+		}
+		capsules = append(capsules, *c)
+	}
+
+	return capsules, rows.Err()
+}
+
+// UpdateCollectionStatus moves a collection leased to owner from pending to
+// status once its thread is over, following the transition table
+func (s *CapsuleStore) UpdateCollectionStatus(id int64, owner string, status CollectionStatus) error {
+	var publishedAt *time.Time
+	if status == COLLECTION_PUBLISHED {
+		now := s.clock.Now().UTC()
+		publishedAt = &now
+	}
+	return s.endCollection(id, owner, status, "published_at = ?", publishedAt)
+}
+
+// ScheduleCollectionRetry records a failed publish attempt of a collection
+// leased to owner that should be retried at nextAttemptAt. A thread cut short
+// resumes from where it stopped.
+func (s *CapsuleStore) ScheduleCollectionRetry(id int64, owner string, lastError string, nextAttemptAt time.Time) error {
+	result, err := s.db.Exec(`
+		UPDATE collections SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status = ? AND lease_owner = ?
+	`, lastError, nextAttemptAt, id, string(COLLECTION_PENDING), owner)
+	if err != nil {
+		return fmt.Errorf("scheduling collection retry: %w", err)
+	}
+	if rowsAffected(result) == 0 {
+		return fmt.Errorf("%w: collection %d is no longer pending by %s", ErrStatusConflict, id, owner)
+	}
+	return nil
+}
+
+// RecordCollectionFailure records a failed publish attempt of a collection
+// leased to owner that won't be retried, leaving the collection in the given
+// status (COLLECTION_FAILED or COLLECTION_DEAD)
+func (s *CapsuleStore) RecordCollectionFailure(id int64, owner string, status CollectionStatus, lastError string) error {
+	if status != COLLECTION_FAILED && status != COLLECTION_DEAD {
+		return fmt.Errorf("%w: a failure can't leave a collection %s", ErrInvalidTransition, status)
+	}
+	return s.endCollection(id, owner, status, "attempts = attempts + 1, last_error = ?, next_attempt_at = NULL", lastError)
+}
+
+// endCollection moves a collection leased to owner from pending to status,
+// applying the extra assignments of set along the way. Members are recorded
+// as they're posted, so any member still pending wasn't posted and fails
+// with the collection.
+func (s *CapsuleStore) endCollection(id int64, owner string, status CollectionStatus, set string, args ...any) error {
+	if !COLLECTION_PENDING.CanTransitionTo(status) {
+		return fmt.Errorf("%w: collection %s to %s", ErrInvalidTransition, COLLECTION_PENDING, status)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE collections SET status = ?, lease_owner = NULL, lease_expires_at = NULL, `+set+`
+		WHERE id = ? AND status = ? AND lease_owner = ?
+	`, append(append([]any{string(status)}, args...), id, string(COLLECTION_PENDING), owner)...)
+	if err != nil {
+		return fmt.Errorf("updating collection status: %w", err)
+	}
+	if rowsAffected(result) == 0 {
+		return fmt.Errorf("%w: collection %d is no longer pending by %s", ErrStatusConflict, id, owner)
+	}
+
+	const lastError = "not posted in the collection thread"
+	e := event{kind: EVENT_FAILED, actor: owner, reason: fmt.Sprintf("collection #%d", id), err: lastError}
+	if _, err := s.setStatuses(tx, STATUS_PENDING, STATUS_FAILED, e, "collection_id = ?", []any{id},
+		"last_error = ?", lastError); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// StartCollectionThread records the intro of the thread of a collection
+// leased to owner, announcing total members
+func (s *CapsuleStore) StartCollectionThread(id int64, owner string, introTweetID string, total int) error {
+	result, err := s.db.Exec(`
+		UPDATE collections SET thread_tweet_id = ?, thread_total = ?, thread_posted = 0
+		WHERE id = ? AND status = 'pending' AND lease_owner = ?
+	`, introTweetID, total, id, owner)
+	if err != nil {
+		return fmt.Errorf("starting collection thread: %w", err)
+	}
+	if rowsAffected(result) == 0 {
+		return fmt.Errorf("%w: collection %d is no longer pending by %s", ErrStatusConflict, id, owner)
+	}
+	return nil
+}
+
+// RecordCollectionMember records a member of the thread of a collection
+// leased to owner. A member posted as postedTweetID becomes the post the
+// rest of the thread replies to and takes status, STATUS_PUBLISHED or
+// STATUS_DELETED_PUBLISHED when its snapshot was posted; a member that
+// couldn't be posted takes STATUS_FAILED with lastError.
+func (s *CapsuleStore) RecordCollectionMember(id int64, owner string, capsuleID int64, status CapsuleStatus, postedTweetID string, lastError string) error {
+	if status != STATUS_PUBLISHED && status != STATUS_DELETED_PUBLISHED && status != STATUS_FAILED {
+		return fmt.Errorf("%w: a collection thread can't leave a member %s", ErrInvalidTransition, status)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var leased int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM collections WHERE id = ? AND status = 'pending' AND lease_owner = ?
+	`, id, owner).Scan(&leased); err != nil {
+		return fmt.Errorf("getting collection lease: %w", err)
+	}
+	if leased == 0 {
+		return fmt.Errorf("%w: collection %d is no longer pending by %s", ErrStatusConflict, id, owner)
+	}
+
+	e := event{actor: owner, reason: fmt.Sprintf("collection #%d", id)}
+	if status == STATUS_FAILED {
+		e.kind = EVENT_FAILED
+		e.err = lastError
+		err = s.setStatus(tx, capsuleID, STATUS_PENDING, status, owner, e, "last_error = ?", lastError)
+	} else {
+		e.kind = EVENT_PUBLISHED
+		e.tweetIDs = []string{postedTweetID}
+		err = s.setStatus(tx, capsuleID, STATUS_PENDING, status, owner, e, "published_at = ?", s.clock.Now().UTC())
+	}
+	if err != nil {
+		return err
+	}
+
+	if status != STATUS_FAILED {
+		if _, err := tx.Exec(`
+			UPDATE collections SET thread_tweet_id = ?, thread_posted = thread_posted + 1 WHERE id = ?
+		`, postedTweetID, id); err != nil {
+			return fmt.Errorf("updating collection thread: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeferCollection gives back the lease of a collection that doesn't fit in
+// the post budget, so it's claimed again at until. A thread cut short
+// resumes from where it stopped.
+func (s *CapsuleStore) DeferCollection(id int64, owner string, until time.Time) error {
+	result, err := s.db.Exec(`
+		UPDATE collections SET next_attempt_at = ?, lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = ? AND status = 'pending' AND lease_owner = ?
+	`, until, id, owner)
+	if err != nil {
+		return fmt.Errorf("deferring collection: %w", err)
+	}
+	if rowsAffected(result) == 0 {
+		return fmt.Errorf("%w: collection %d is no longer pending by %s", ErrStatusConflict, id, owner)
+	}
+	return nil
+}
@@ -3,110 +3,281 @@ package storage
 import (
 	"database/sql"
 	"fmt"
-	"log/slog"
-	"os"
-	"path/filepath"
-	"sort"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "modernc.org/sqlite"
 )
 
+// Dialect is the SQL flavor of the database behind a DB
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// SQLite allows one writer at a time. Writes go through a single connection,
+// whose callers wait their turn in the database/sql queue, and reads through
+// a pool of read-only connections that WAL lets run alongside the writer.
+const (
+	sqliteBusyTimeout = 5 * time.Second // how long a connection waits for a lock before SQLITE_BUSY
+	sqliteBusyRetries = 5               // attempts of a write that still got SQLITE_BUSY
+	sqliteRetryDelay  = 50 * time.Millisecond
+)
+
+// DB is a database connection that speaks the dialect of its driver. Queries
+// are written with ? placeholders and rebound for the driver.
 type DB struct {
-	Conn *sql.DB
+	Conn    *sql.DB // every connection of PostgreSQL, the writer of SQLite
+	Dialect Dialect
+
+	reads *sql.DB // read-only pool of SQLite, nil to read through Conn
+	path  string  // file of SQLite, empty in memory
+}
+
+// Open connects to the database named by the DSN. postgres:// and
+// postgresql:// URLs open PostgreSQL; anything else is a SQLite path,
+// optionally prefixed with sqlite://.
+func Open(dsn string) (*DB, error) {
+	if path, ok := SQLitePath(dsn); ok {
+		return New(path)
+	}
+	return openPostgres(dsn)
 }
 
+// SQLitePath returns the file path of the SQLite database named by the DSN,
+// and false when the DSN names a PostgreSQL database
+func SQLitePath(dsn string) (string, bool) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "", false
+	}
+	return strings.TrimPrefix(dsn, "sqlite://"), true
+}
+
+// New opens the SQLite database at dbPath with a single writer connection
+// and a read pool. Every connection waits up to sqliteBusyTimeout for locks
+// and enforces foreign keys; the writer also turns WAL mode on and begins
+// its transactions IMMEDIATE, taking the write lock up front rather than
+// failing to upgrade a read lock halfway through.
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite", dbPath)
+	pragmas := fmt.Sprintf("_pragma=busy_timeout(%d)&_pragma=foreign_keys(1)", sqliteBusyTimeout.Milliseconds())
 
+	conn, err := sql.Open("sqlite", sqliteDSN(dbPath, pragmas+"&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate"))
 	if err != nil {
 		return nil, fmt.Errorf("opening database, %w", err)
 	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	conn.SetConnMaxLifetime(0)
 
 	// Verify the connection works
 	if err := conn.Ping(); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("pinging database: %w", err)
 	}
 
-	// Enable WAL mode for better read/write performance
-	if _, err := conn.Exec("PRAGMA journal_mode=WAL"); err != nil {
-		return nil, fmt.Errorf("enabling WAL mode: %w", err)
+	db := &DB{Conn: conn, Dialect: SQLite}
+
+	// Every connection to an in-memory database opens a database of its own
+	if dbPath == ":memory:" || strings.Contains(dbPath, "mode=memory") {
+		return db, nil
 	}
 
-	// Enable foreign keys
-	if _, err := conn.Exec("PRAGMA foreign_keys=ON"); err != nil {
-		return nil, fmt.Errorf("enabling foreign keys: %w", err)
+	reads, err := sql.Open("sqlite", sqliteDSN(dbPath, pragmas+"&_pragma=query_only(1)"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("opening read pool, %w", err)
+	}
+	reads.SetMaxOpenConns(max(4, runtime.NumCPU()))
+	if err := reads.Ping(); err != nil {
+		conn.Close()
+		reads.Close()
+		return nil, fmt.Errorf("pinging read pool: %w", err)
 	}
+	db.reads = reads
+	db.path = dbPath
 
-	return &DB{Conn: conn}, nil
+	return db, nil
 }
 
-// Migrate reads all .sql files from the given directory and executes them
-// in alphabetical order. Migrations are tracked in a migrations table
-// so they only run once.
-func (db *DB) Migrate(migrationsDir string) error {
-	// Migrations tracking table
-	_, err := db.Conn.Exec(`
-		CREATE TABLE IF NOT EXISTS migrations (
-			filename TEXT PRIMARY KEY,
-			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+// sqliteDSN appends connection parameters to a SQLite path, which may
+// already carry some
+func sqliteDSN(path string, params string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + params
+	}
+	return path + "?" + params
+}
 
+func openPostgres(dsn string) (*DB, error) {
+	conn, err := sql.Open("pgx", dsn)
 	if err != nil {
-		return fmt.Errorf("creating migrations table: %w", err)
+		return nil, fmt.Errorf("opening database, %w", err)
 	}
 
-	// Read migration files
-	entries, err := os.ReadDir(migrationsDir)
-	if err != nil {
-		return fmt.Errorf("error reading migrations directory: %w", err)
+	if err := conn.Ping(); err != nil {
+		return nil, fmt.Errorf("pinging database: %w", err)
 	}
 
-	// Sort to ensure consistent execution order
-	var files []string
-	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".sql" {
-			files = append(files, entry.Name())
-		}
+	return &DB{Conn: conn, Dialect: Postgres}, nil
+}
+
+func (db *DB) Close() error {
+	if db.reads != nil {
+		db.reads.Close()
 	}
-	sort.Strings(files)
+	return db.Conn.Close()
+}
 
-	for _, filename := range files {
-		// Check if already applied
-		var count int
-		err := db.Conn.QueryRow("SELECT COUNT(*) FROM migrations WHERE filename = ?", filename).Scan(&count)
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := db.retryBusy(func() error {
+		var err error
+		result, err = db.Conn.Exec(db.rebind(query), args...)
+		return err
+	})
+	return result, err
+}
 
-		if err != nil {
-			return fmt.Errorf("checking migration %s: %w", filename, err)
-		}
+// Query runs SELECT statements on the read pool, and anything else, such as
+// UPDATE … RETURNING, on the writer
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	if pool := db.readPool(query); pool != nil {
+		return pool.Query(db.rebind(query), args...)
+	}
 
-		if count > 0 {
-			slog.Debug("migration already applied, skipping", "file", filename)
-			continue
-		}
+	var rows *sql.Rows
+	err := db.retryBusy(func() error {
+		var err error
+		rows, err = db.Conn.Query(db.rebind(query), args...)
+		return err
+	})
+	return rows, err
+}
 
-		// Read and execute migration
-		path := filepath.Join(migrationsDir, filename)
-		content, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("reading migration %s: %w", filename, err)
-		}
+// QueryRow runs SELECT statements on the read pool like Query. Anything
+// else, such as INSERT … RETURNING, runs on the writer once the row is
+// scanned, so it's retried while SQLite is busy like other writes.
+func (db *DB) QueryRow(query string, args ...any) *Row {
+	if pool := db.readPool(query); pool != nil {
+		return &Row{row: pool.QueryRow(db.rebind(query), args...)}
+	}
 
-		if _, err := db.Conn.Exec(string(content)); err != nil {
-			return fmt.Errorf("executing migration %s: %w", filename, err)
-		}
+	query = db.rebind(query)
+	return &Row{write: func(dest ...any) error {
+		return db.retryBusy(func() error {
+			return db.Conn.QueryRow(query, args...).Scan(dest...)
+		})
+	}}
+}
 
-		// Record migration
-		if _, err := db.Conn.Exec("INSERT INTO migrations (filename) VALUES (?)", filename); err != nil {
-			return fmt.Errorf("recording migration %s: %w", filename, err)
-		}
+// Row is the result of QueryRow
+type Row struct {
+	row   *sql.Row
+	write func(dest ...any) error // runs and scans a statement that writes
+}
 
-		slog.Info("migration applied", "file", filename)
+func (r *Row) Scan(dest ...any) error {
+	if r.write != nil {
+		return r.write(dest...)
 	}
+	return r.row.Scan(dest...)
+}
 
-	return nil
+func (db *DB) Begin() (*Tx, error) {
+	var tx *sql.Tx
+	err := db.retryBusy(func() error {
+		var err error
+		tx, err = db.Conn.Begin()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
 }
 
-func (db *DB) Close() error {
-	return db.Conn.Close()
+// readPool returns the read pool for a query that only reads, or nil when
+// the query goes to the writer
+func (db *DB) readPool(query string) *sql.DB {
+	if db.reads == nil {
+		return nil
+	}
+	q := strings.TrimLeftFunc(query, unicode.IsSpace)
+	if len(q) <= len("SELECT") || !strings.EqualFold(q[:6], "SELECT") || !unicode.IsSpace(rune(q[6])) {
+		return nil
+	}
+	return db.reads
+}
+
+// retryBusy runs a write again, with a doubling delay, while SQLite reports
+// the database busy even after waiting sqliteBusyTimeout. That can still
+// happen when another process holds the write lock for long, such as a
+// backup or a bot sharing the file.
+func (db *DB) retryBusy(write func() error) error {
+	err := write()
+	delay := sqliteRetryDelay
+	for attempt := 1; attempt < sqliteBusyRetries && db.Dialect == SQLite && isBusy(err); attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = write()
+	}
+	return err
+}
+
+// Tx is a transaction that rebinds its queries like DB
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *Row {
+	return &Row{row: tx.Tx.QueryRow(tx.db.rebind(query), args...)}
+}
+
+// rebind turns the ? placeholders of a query into $1, $2... for PostgreSQL.
+// Question marks inside string literals are left alone.
+func (db *DB) rebind(query string) string {
+	if db.Dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// skipLocked returns the locking clause that lets concurrent claims skip rows
+// another transaction is claiming. SQLite serializes writers and needs none.
+func (db *DB) skipLocked() string {
+	if db.Dialect == Postgres {
+		return "FOR UPDATE SKIP LOCKED"
+	}
+	return ""
 }
@@ -0,0 +1,33 @@
+package storage
+
+import "fmt"
+
+// DRY_RUN_ID_PREFIX starts the synthetic IDs of tweets recorded in dry-run mode
+const DRY_RUN_ID_PREFIX = "dry-run-"
+
+// RecordDryRunPost keeps a tweet the bot would have posted and returns its
+// synthetic ID. Its text is encrypted like the snapshots it may repeat.
+func (s *CapsuleStore) RecordDryRunPost(text string, quoteTweetID string, replyToID string) (string, error) {
+	sealed, keyID, err := s.keys.encrypt(text)
+	if err != nil {
+		return "", err
+	}
+
+	var id int64
+	err = s.db.QueryRow(`
+		INSERT INTO dry_run_posts (text, quote_tweet_id, reply_to_id, created_at, key_id) VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`, sealed, nullIfEmpty(quoteTweetID), nullIfEmpty(replyToID), s.clock.Now().UTC(), keyID).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("recording dry-run post: %w", err)
+	}
+
+	return fmt.Sprintf("%s%d", DRY_RUN_ID_PREFIX, id), nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// EncryptionEnabled reports whether tweet snapshots are encrypted at rest
+func (s *CapsuleStore) EncryptionEnabled() bool {
+	return s.keys != nil
+}
+
+// RotateDataKey creates a new data key once the active one is older than
+// maxAge, and reports whether it did. Keys created by other instances are
+// picked up first, so only one of them rotates.
+func (s *CapsuleStore) RotateDataKey(maxAge time.Duration) (bool, error) {
+	if s.keys == nil || maxAge <= 0 {
+		return false, nil
+	}
+	if err := s.keys.reload(true); err != nil {
+		return false, err
+	}
+
+	_, createdAt := s.keys.active()
+	if s.clock.Now().Sub(createdAt) < maxAge {
+		return false, nil
+	}
+	if err := s.keys.Rotate(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReencryptBatch seals up to limit snapshots and dry-run posts that are in
+// plain text or under a retired key with the active key, and returns how
+// many it rewrote. A row changed in the meantime is left for the next batch.
+func (s *CapsuleStore) ReencryptBatch(limit int) (int, error) {
+	if s.keys == nil {
+		return 0, nil
+	}
+	if err := s.keys.reload(true); err != nil {
+		return 0, err
+	}
+	activeID, _ := s.keys.active()
+
+	count, err := s.reencryptCapsules(activeID, limit)
+	if err != nil || count >= limit {
+		return count, err
+	}
+	parts, err := s.reencryptParts(activeID, limit-count)
+	count += parts
+	if err != nil || count >= limit {
+		return count, err
+	}
+	posts, err := s.reencryptDryRunPosts(activeID, limit-count)
+	return count + posts, err
+}
+
+func (s *CapsuleStore) reencryptCapsules(activeID string, limit int) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT id, tweet_text, key_id
+		FROM capsules
+		WHERE key_id IS NULL OR key_id <> ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, activeID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("querying capsules to re-encrypt: %w", err)
+	}
+
+	type snapshot struct {
+		id    int64
+		text  string
+		keyID *string
+	}
+	var snapshots []snapshot
+	for rows.Next() {
+		var sn snapshot
+		if err := rows.Scan(&sn.id, &sn.text, &sn.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning capsule: %w", err)
+		}
+		snapshots = append(snapshots, sn)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("querying capsules to re-encrypt: %w", err)
+	}
+
+	count := 0
+	for _, sn := range snapshots {
+		plaintext, err := s.keys.decrypt(sn.text, sn.keyID)
+		if err != nil {
+			return count, fmt.Errorf("capsule %d: %w", sn.id, err)
+		}
+		text, keyID, err := s.keys.encrypt(plaintext)
+		if err != nil {
+			return count, err
+		}
+		result, err := s.db.Exec(`
+			UPDATE capsules SET tweet_text = ?, key_id = ? WHERE id = ? AND tweet_text = ?
+		`, text, keyID, sn.id, sn.text)
+		if err != nil {
+			return count, fmt.Errorf("re-encrypting capsule %d: %w", sn.id, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *CapsuleStore) reencryptParts(activeID string, limit int) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT capsule_id, position, tweet_text, key_id
+		FROM capsule_parts
+		WHERE key_id IS NULL OR key_id <> ?
+		ORDER BY capsule_id ASC, position ASC
+		LIMIT ?
+	`, activeID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("querying capsule parts to re-encrypt: %w", err)
+	}
+
+	type snapshot struct {
+		capsuleID int64
+		position  int
+		text      string
+		keyID     *string
+	}
+	var snapshots []snapshot
+	for rows.Next() {
+		var sn snapshot
+		if err := rows.Scan(&sn.capsuleID, &sn.position, &sn.text, &sn.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning capsule part: %w", err)
+		}
+		snapshots = append(snapshots, sn)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("querying capsule parts to re-encrypt: %w", err)
+	}
+
+	count := 0
+	for _, sn := range snapshots {
+		plaintext, err := s.keys.decrypt(sn.text, sn.keyID)
+		if err != nil {
+			return count, fmt.Errorf("capsule %d part %d: %w", sn.capsuleID, sn.position, err)
+		}
+		text, keyID, err := s.keys.encrypt(plaintext)
+		if err != nil {
+			return count, err
+		}
+		result, err := s.db.Exec(`
+			UPDATE capsule_parts SET tweet_text = ?, key_id = ? WHERE capsule_id = ? AND position = ? AND tweet_text = ?
+		`, text, keyID, sn.capsuleID, sn.position, sn.text)
+		if err != nil {
+			return count, fmt.Errorf("re-encrypting capsule %d part %d: %w", sn.capsuleID, sn.position, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *CapsuleStore) reencryptDryRunPosts(activeID string, limit int) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT id, text, key_id
+		FROM dry_run_posts
+		WHERE key_id IS NULL OR key_id <> ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, activeID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("querying dry-run posts to re-encrypt: %w", err)
+	}
+
+	type post struct {
+		id    int64
+		text  string
+		keyID *string
+	}
+	var posts []post
+	for rows.Next() {
+		var p post
+		if err := rows.Scan(&p.id, &p.text, &p.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning dry-run post: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("querying dry-run posts to re-encrypt: %w", err)
+	}
+
+	count := 0
+	for _, p := range posts {
+		plaintext, err := s.keys.decrypt(p.text, p.keyID)
+		if err != nil {
+			return count, fmt.Errorf("dry-run post %d: %w", p.id, err)
+		}
+		text, keyID, err := s.keys.encrypt(plaintext)
+		if err != nil {
+			return count, err
+		}
+		result, err := s.db.Exec(`
+			UPDATE dry_run_posts SET text = ?, key_id = ? WHERE id = ? AND text = ?
+		`, text, keyID, p.id, p.text)
+		if err != nil {
+			return count, fmt.Errorf("re-encrypting dry-run post %d: %w", p.id, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,505 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErasureRequest names the user to forget. Handle also matches the tweets
+// of capsules captured before author IDs were recorded.
+type ErasureRequest struct {
+	UserID string
+	Handle string // with or without the @, optional
+	DryRun bool   // count what would be erased, and change nothing
+}
+
+// ErrErasureKeyMissing is returned when an erasure, or a check against past
+// ones, needs the erasure key and none is set
+var ErrErasureKeyMissing = errors.New("no erasure key is set")
+
+// Erasure is the receipt of an erasure. It names the user only by a keyed
+// hash of their ID, which can't be traced back to them without the erasure
+// key.
+type Erasure struct {
+	ID                 int64 // 0 for a dry run
+	SubjectHash        string
+	ErasedAt           time.Time
+	CapsulesDeleted    int // capsules the user requested, or whose tweet they wrote
+	CollectionsDeleted int
+	PreferencesDeleted int
+	DryRunPostsDeleted int // dry-run posts about the user or an erased capsule
+	TweetsTombstoned   int // tweets of the user that can't be captured again
+}
+
+// subjectHash is how erasure receipts name a user: the HMAC-SHA256 of their
+// ID under the erasure key. A plain hash of an ID would be reversed by hashing
+// every ID in turn, which the key rules out.
+func subjectHash(key []byte, userID string) (string, error) {
+	if len(key) == 0 {
+		return "", ErrErasureKeyMissing
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *Row
+}
+
+// TweetErased reports whether the tweet was erased, and must not be captured
+func (s *CapsuleStore) TweetErased(tweetID string) (bool, error) {
+	return tweetErased(s.db, tweetID)
+}
+
+func tweetErased(q rowQuerier, tweetID string) (bool, error) {
+	var one int
+	err := q.QueryRow("SELECT 1 FROM erased_tweets WHERE tweet_id = ?", tweetID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking erased tweet %s: %w", tweetID, err)
+	}
+	return true, nil
+}
+
+// requesterErased reports whether the user was erased after the given time
+func requesterErased(q rowQuerier, key []byte, userID string, since time.Time) (bool, error) {
+	var one int
+	hash, err := subjectHash(key, userID)
+	if err != nil {
+		// Without the key no receipt can be matched, which is only safe
+		// while there are none
+		err = q.QueryRow("SELECT 1 FROM erasures LIMIT 1").Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("checking erasures: %w", err)
+		}
+		return false, fmt.Errorf("checking erasures: %w", ErrErasureKeyMissing)
+	}
+
+	err = q.QueryRow(`
+		SELECT 1 FROM erasures WHERE subject_hash = ? AND erased_at >= ? LIMIT 1
+	`, hash, since.UTC()).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking erasures: %w", err)
+	}
+	return true, nil
+}
+
+// EraseUser deletes every capsule the user requested or whose tweet they
+// wrote, with its parts, milestones and timeline, their collections and
+// preferences, and the dry-run posts about them or the erased capsules. The
+// tweets the user wrote are kept as bare tombstones so they can't be captured
+// again. Everything happens in one transaction, recorded by the returned
+// receipt.
+func (s *CapsuleStore) EraseUser(req ErasureRequest) (*Erasure, error) {
+	if req.UserID == "" {
+		return nil, errors.New("erasing user: no user ID")
+	}
+	hash, err := subjectHash(s.erasureKey, req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("erasing user: %w", err)
+	}
+	handle := strings.TrimPrefix(req.Handle, "@")
+	receipt := &Erasure{SubjectHash: hash, ErasedAt: s.clock.Now().UTC()}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, tweet_id, requester_id, requester_handle
+		FROM capsules
+		WHERE requester_id = ? OR tweet_author_id = ?
+			OR (tweet_author_id = '' AND ? <> '' AND LOWER(tweet_author) = LOWER(?))
+		ORDER BY id ASC
+	`, req.UserID, req.UserID, handle, handle)
+	if err != nil {
+		return nil, fmt.Errorf("querying capsules to erase: %w", err)
+	}
+	var capsuleIDs []int64
+	var erasedTweets []string
+	handles := []string{handle}
+	for rows.Next() {
+		var id int64
+		var tweetID, requesterID, requesterHandle string
+		if err := rows.Scan(&id, &tweetID, &requesterID, &requesterHandle); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning capsule to erase: %w", err)
+		}
+		capsuleIDs = append(capsuleIDs, id)
+		erasedTweets = append(erasedTweets, tweetID)
+		if requesterID == req.UserID {
+			handles = append(handles, requesterHandle)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying capsules to erase: %w", err)
+	}
+
+	// Only the tweets the user wrote are tombstoned; tweets they merely
+	// saved can still be saved by others
+	tombstones, err := authoredTweets(tx, req.UserID, handle)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerHandles, err := collectionOwnerHandles(tx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	handles = append(handles, ownerHandles...)
+
+	var republishes []string
+	for _, id := range capsuleIDs {
+		parts, err := partTweetIDs(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		erasedTweets = append(erasedTweets, parts...)
+		posted, err := postedTweetIDs(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		republishes = append(republishes, posted...)
+		if _, err := tx.Exec("DELETE FROM capsule_events WHERE capsule_id = ?", id); err != nil {
+			return nil, fmt.Errorf("erasing events of capsule %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM capsule_milestones WHERE capsule_id = ?", id); err != nil {
+			return nil, fmt.Errorf("erasing milestones of capsule %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM capsule_parts WHERE capsule_id = ?", id); err != nil {
+			return nil, fmt.Errorf("erasing parts of capsule %d: %w", id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM capsules WHERE id = ?", id); err != nil {
+			return nil, fmt.Errorf("erasing capsule %d: %w", id, err)
+		}
+	}
+	receipt.CapsulesDeleted = len(capsuleIDs)
+
+	if receipt.DryRunPostsDeleted, err = eraseDryRunPosts(tx, s.keys, erasedTweets, republishes, handles); err != nil {
+		return nil, err
+	}
+
+	// Capsules of others never join a collection they don't own, but an
+	// import could have put them there
+	if _, err := tx.Exec(`
+		UPDATE capsules SET collection_id = NULL
+		WHERE collection_id IN (SELECT id FROM collections WHERE owner_id = ?)
+	`, req.UserID); err != nil {
+		return nil, fmt.Errorf("detaching collections: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM collections WHERE owner_id = ?", req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("erasing collections: %w", err)
+	}
+	receipt.CollectionsDeleted = rowsAffected(result)
+
+	result, err = tx.Exec("DELETE FROM user_preferences WHERE user_id = ?", req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("erasing preferences: %w", err)
+	}
+	receipt.PreferencesDeleted = rowsAffected(result)
+
+	err = tx.QueryRow(`
+		INSERT INTO erasures (subject_hash, erased_at, capsules_deleted, collections_deleted, preferences_deleted,
+			dry_run_posts_deleted, tweets_tombstoned)
+		VALUES (?, ?, ?, ?, ?, ?, 0)
+		RETURNING id
+	`, receipt.SubjectHash, receipt.ErasedAt, receipt.CapsulesDeleted, receipt.CollectionsDeleted, receipt.PreferencesDeleted,
+		receipt.DryRunPostsDeleted).Scan(&receipt.ID)
+	if err != nil {
+		return nil, fmt.Errorf("inserting erasure receipt: %w", err)
+	}
+
+	for _, tweetID := range tombstones {
+		result, err := tx.Exec(`
+			INSERT INTO erased_tweets (tweet_id, erasure_id) VALUES (?, ?)
+			ON CONFLICT (tweet_id) DO NOTHING
+		`, tweetID, receipt.ID)
+		if err != nil {
+			return nil, fmt.Errorf("inserting tombstone: %w", err)
+		}
+		receipt.TweetsTombstoned += rowsAffected(result)
+	}
+	if _, err := tx.Exec("UPDATE erasures SET tweets_tombstoned = ? WHERE id = ?", receipt.TweetsTombstoned, receipt.ID); err != nil {
+		return nil, fmt.Errorf("updating erasure receipt: %w", err)
+	}
+
+	if req.DryRun {
+		receipt.ID = 0
+		return receipt, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing erasure: %w", err)
+	}
+
+	return receipt, nil
+}
+
+// authoredTweets returns the saved tweets the user wrote, thread parts
+// included
+func authoredTweets(tx *Tx, userID string, handle string) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT tweet_id FROM capsules
+		WHERE tweet_author_id = ? OR (tweet_author_id = '' AND ? <> '' AND LOWER(tweet_author) = LOWER(?))
+		UNION
+		SELECT p.tweet_id FROM capsule_parts p
+		JOIN capsules c ON c.id = p.capsule_id
+		WHERE c.tweet_author_id = ? OR (c.tweet_author_id = '' AND ? <> '' AND LOWER(c.tweet_author) = LOWER(?))
+	`, userID, handle, handle, userID, handle, handle)
+	if err != nil {
+		return nil, fmt.Errorf("querying tweets to tombstone: %w", err)
+	}
+	defer rows.Close()
+
+	var tweetIDs []string
+	for rows.Next() {
+		var tweetID string
+		if err := rows.Scan(&tweetID); err != nil {
+			return nil, fmt.Errorf("scanning tweet to tombstone: %w", err)
+		}
+		tweetIDs = append(tweetIDs, tweetID)
+	}
+	return tweetIDs, rows.Err()
+}
+
+func partTweetIDs(tx *Tx, capsuleID int64) ([]string, error) {
+	rows, err := tx.Query("SELECT tweet_id FROM capsule_parts WHERE capsule_id = ?", capsuleID)
+	if err != nil {
+		return nil, fmt.Errorf("querying parts of capsule %d: %w", capsuleID, err)
+	}
+	defer rows.Close()
+
+	var tweetIDs []string
+	for rows.Next() {
+		var tweetID string
+		if err := rows.Scan(&tweetID); err != nil {
+			return nil, fmt.Errorf("scanning part of capsule %d: %w", capsuleID, err)
+		}
+		tweetIDs = append(tweetIDs, tweetID)
+	}
+	return tweetIDs, rows.Err()
+}
+
+// postedTweetIDs returns the republishes recorded for a capsule: those in its
+// timeline or its milestones, and one posted but not completed yet
+func postedTweetIDs(tx *Tx, capsuleID int64) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT tweet_ids FROM capsule_events WHERE capsule_id = ? AND event = ? AND tweet_ids <> ''
+		UNION
+		SELECT posted_tweet_id FROM capsule_milestones WHERE capsule_id = ? AND posted_tweet_id IS NOT NULL
+		UNION
+		SELECT posted_tweet_id FROM capsules WHERE id = ? AND posted_tweet_id IS NOT NULL
+	`, capsuleID, EVENT_PUBLISHED, capsuleID, capsuleID)
+	if err != nil {
+		return nil, fmt.Errorf("querying republishes of capsule %d: %w", capsuleID, err)
+	}
+	defer rows.Close()
+
+	var tweetIDs []string
+	for rows.Next() {
+		var ids string
+		if err := rows.Scan(&ids); err != nil {
+			return nil, fmt.Errorf("scanning republish of capsule %d: %w", capsuleID, err)
+		}
+		tweetIDs = append(tweetIDs, strings.Split(ids, ",")...)
+	}
+	return tweetIDs, rows.Err()
+}
+
+func collectionOwnerHandles(tx *Tx, userID string) ([]string, error) {
+	rows, err := tx.Query("SELECT DISTINCT owner_handle FROM collections WHERE owner_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("querying collection owners: %w", err)
+	}
+	defer rows.Close()
+
+	var handles []string
+	for rows.Next() {
+		var handle string
+		if err := rows.Scan(&handle); err != nil {
+			return nil, fmt.Errorf("scanning collection owner: %w", err)
+		}
+		handles = append(handles, handle)
+	}
+	return handles, rows.Err()
+}
+
+// eraseDryRunPosts deletes the dry-run posts about an erased user and
+// returns how many there were: posts quoting or replying to an erased tweet,
+// the republishes of the erased capsules, posts tagging one of the handles,
+// and every post further down their threads or replying to the same
+// mention. Deleted-tweet republishes quote nothing, so they're only found
+// through the capsules that posted them.
+func eraseDryRunPosts(tx *Tx, keys *Keyring, erasedTweets []string, republishes []string, handles []string) (int, error) {
+	type dryRunPost struct {
+		id        int64
+		text      string
+		keyID     *string
+		quoteID   *string
+		replyToID *string
+	}
+
+	rows, err := tx.Query("SELECT id, text, key_id, quote_tweet_id, reply_to_id FROM dry_run_posts ORDER BY id ASC")
+	if err != nil {
+		return 0, fmt.Errorf("querying dry-run posts: %w", err)
+	}
+	var posts []dryRunPost
+	for rows.Next() {
+		var p dryRunPost
+		if err := rows.Scan(&p.id, &p.text, &p.keyID, &p.quoteID, &p.replyToID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning dry-run post: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("querying dry-run posts: %w", err)
+	}
+	for i := range posts {
+		if posts[i].text, err = keys.decrypt(posts[i].text, posts[i].keyID); err != nil {
+			return 0, fmt.Errorf("dry-run post %d: %w", posts[i].id, err)
+		}
+	}
+
+	var tags []*regexp.Regexp
+	for _, handle := range handles {
+		if handle = strings.TrimPrefix(handle, "@"); handle != "" {
+			tags = append(tags, regexp.MustCompile(`(?i)@`+regexp.QuoteMeta(handle)+`\b`))
+		}
+	}
+
+	erased := make(map[string]bool)
+	for _, tweetID := range erasedTweets {
+		erased[tweetID] = true
+	}
+	// Dry-run posts to erase, by synthetic tweet ID, and the mentions of
+	// the user the bot replied to
+	erasedPosts := make(map[string]bool)
+	for _, tweetID := range republishes {
+		erasedPosts[tweetID] = true
+	}
+	mentions := make(map[string]bool)
+
+	// Threads reply to earlier posts, so a pass finds the next level down
+	for found := true; found; {
+		found = false
+		for _, p := range posts {
+			tweetID := fmt.Sprintf("%s%d", DRY_RUN_ID_PREFIX, p.id)
+			if erasedPosts[tweetID] {
+				continue
+			}
+
+			var replyTo string
+			if p.replyToID != nil {
+				replyTo = *p.replyToID
+			}
+			tagged := slices.ContainsFunc(tags, func(tag *regexp.Regexp) bool { return tag.MatchString(p.text) })
+			if !tagged && !erased[replyTo] && !erasedPosts[replyTo] && !mentions[replyTo] &&
+				(p.quoteID == nil || !erased[*p.quoteID]) {
+				continue
+			}
+
+			erasedPosts[tweetID] = true
+			if tagged && replyTo != "" && !strings.HasPrefix(replyTo, DRY_RUN_ID_PREFIX) {
+				mentions[replyTo] = true
+			}
+			found = true
+		}
+	}
+
+	deleted := 0
+	for _, p := range posts {
+		if !erasedPosts[fmt.Sprintf("%s%d", DRY_RUN_ID_PREFIX, p.id)] {
+			continue
+		}
+		result, err := tx.Exec("DELETE FROM dry_run_posts WHERE id = ?", p.id)
+		if err != nil {
+			return 0, fmt.Errorf("erasing dry-run posts: %w", err)
+		}
+		deleted += rowsAffected(result)
+	}
+	return deleted, nil
+}
+
+func rowsAffected(result sql.Result) int {
+	n, _ := result.RowsAffected()
+	return int(n)
+}
+
+// Erasures returns every erasure receipt, newest first
+func (s *CapsuleStore) Erasures() ([]Erasure, error) {
+	rows, err := s.db.Query(`
+		SELECT id, subject_hash, erased_at, capsules_deleted, collections_deleted, preferences_deleted,
+			dry_run_posts_deleted, tweets_tombstoned
+		FROM erasures
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying erasures: %w", err)
+	}
+	defer rows.Close()
+
+	var erasures []Erasure
+	for rows.Next() {
+		var e Erasure
+		if err := rows.Scan(&e.ID, &e.SubjectHash, &e.ErasedAt, &e.CapsulesDeleted, &e.CollectionsDeleted, &e.PreferencesDeleted,
+			&e.DryRunPostsDeleted, &e.TweetsTombstoned); err != nil {
+			return nil, fmt.Errorf("scanning erasure: %w", err)
+		}
+		erasures = append(erasures, e)
+	}
+	return erasures, rows.Err()
+}
+
+// ErasedTweets returns the tombstoned tweet IDs, for exports
+func (s *CapsuleStore) ErasedTweets() ([]string, error) {
+	rows, err := s.db.Query("SELECT tweet_id FROM erased_tweets ORDER BY tweet_id")
+	if err != nil {
+		return nil, fmt.Errorf("querying erased tweets: %w", err)
+	}
+	defer rows.Close()
+
+	var tweetIDs []string
+	for rows.Next() {
+		var tweetID string
+		if err := rows.Scan(&tweetID); err != nil {
+			return nil, fmt.Errorf("scanning erased tweet: %w", err)
+		}
+		tweetIDs = append(tweetIDs, tweetID)
+	}
+	return tweetIDs, rows.Err()
+}
+
+// TombstoneTweet keeps an erased tweet from being captured, for imports. It
+// reports whether the tweet wasn't tombstoned yet.
+func (s *CapsuleStore) TombstoneTweet(tweetID string) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO erased_tweets (tweet_id) VALUES (?)
+		ON CONFLICT (tweet_id) DO NOTHING
+	`, tweetID)
+	if err != nil {
+		return false, fmt.Errorf("inserting tombstone: %w", err)
+	}
+	return rowsAffected(result) > 0, nil
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of capsule events
+const (
+	EVENT_CREATED         = "created"
+	EVENT_IMPORTED        = "imported"
+	EVENT_CANCELLED       = "cancelled"
+	EVENT_CLAIMED         = "claimed"
+	EVENT_LEASE_RELEASED  = "lease_released"
+	EVENT_RETRY_SCHEDULED = "retry_scheduled"
+	EVENT_FAILED          = "failed"
+	EVENT_DEFERRED        = "deferred"
+	EVENT_PUBLISHED       = "published"
+	EVENT_STATUS_CHANGED  = "status_changed"
+	EVENT_TWEET_GONE      = "tweet_gone"
+)
+
+// Actors of capsule events besides users and the instances holding a lease
+const (
+	ACTOR_SYSTEM   = "system"
+	ACTOR_IMPORT   = "import"
+	ACTOR_VERIFIER = "verifier"
+)
+
+// CapsuleEvent is one entry of the timeline of a capsule
+type CapsuleEvent struct {
+	ID         int64
+	CapsuleID  int64
+	Event      string
+	FromStatus string
+	ToStatus   string
+	Actor      string // user:<id>, the instance that held the lease, or one of the ACTOR_ constants
+	Reason     *string
+	Error      *string
+	TweetIDs   []string // related tweets, such as the posted republish
+	CreatedAt  time.Time
+}
+
+// event describes the events to record for the capsules matched by a
+// condition, before the change is applied to them
+type event struct {
+	kind     string
+	toStatus string // empty when the status doesn't change
+	actor    string // empty for the lease owner of the capsule, or ACTOR_SYSTEM
+	reason   string
+	err      string
+	tweetIDs []string
+}
+
+// userActor is the actor of events caused by a Twitter user
+func userActor(userID string) string {
+	return "user:" + userID
+}
+
+// recordEvents appends the event to the timeline of every capsule matching
+// where, with the status they have before the change. It must run in the
+// transaction of the change, ahead of it.
+func (s *CapsuleStore) recordEvents(tx *Tx, e event, where string, args ...any) error {
+	// PostgreSQL takes untyped parameters of a select list for text
+	createdAt := "?"
+	if s.db.Dialect == Postgres {
+		createdAt = "CAST(? AS TIMESTAMP)"
+	}
+	_, err := tx.Exec(`
+		INSERT INTO capsule_events (capsule_id, event, from_status, to_status, actor, reason, error, tweet_ids, created_at)
+		SELECT id, ?, status, COALESCE(?, status), COALESCE(?, lease_owner, ?), ?, ?, ?, `+createdAt+`
+		FROM capsules
+		WHERE `+where,
+		append([]any{
+			e.kind, nullIfEmpty(e.toStatus), nullIfEmpty(e.actor), ACTOR_SYSTEM,
+			nullIfEmpty(e.reason), nullIfEmpty(e.err), strings.Join(e.tweetIDs, ","), s.clock.Now().UTC(),
+		}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("recording %s event: %w", e.kind, err)
+	}
+	return nil
+}
+
+// Timeline returns the events of a capsule, oldest first
+func (s *CapsuleStore) Timeline(capsuleID int64) ([]CapsuleEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, capsule_id, event, from_status, to_status, actor, reason, error, tweet_ids, created_at
+		FROM capsule_events
+		WHERE capsule_id = ?
+		ORDER BY id ASC
+	`, capsuleID)
+	if err != nil {
+		return nil, fmt.Errorf("querying capsule events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []CapsuleEvent
+	for rows.Next() {
+		var e CapsuleEvent
+		var tweetIDs string
+		if err := rows.Scan(&e.ID, &e.CapsuleID, &e.Event, &e.FromStatus, &e.ToStatus, &e.Actor, &e.Reason, &e.Error,
+			&tweetIDs, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning capsule event: %w", err)
+		}
+		if tweetIDs != "" {
+			e.TweetIDs = strings.Split(tweetIDs, ",")
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/envelope"
+)
+
+// ErrMasterKeyMissing is returned when the database holds encrypted
+// snapshots but no master key was given to read them
+var ErrMasterKeyMissing = errors.New("database holds encrypted snapshots but no master key is set")
+
+// Keyring holds the data keys of the database, unwrapped with the master
+// key. New snapshots are sealed with the active key, the newest one that
+// isn't retired. A nil Keyring stores snapshots in plain text.
+type Keyring struct {
+	db       *DB
+	clock    clock.Clock
+	master   *envelope.MasterKey
+	previous *envelope.MasterKey
+
+	mu       sync.RWMutex
+	keys     map[string][]byte
+	activeID string
+	activeAt time.Time
+}
+
+// OpenKeyring loads the data keys of the database, creating the first one
+// if needed. Keys wrapped by the previous master key are rewrapped by the
+// current one, which is how the master key rotates. Without a master key it
+// returns a nil Keyring, unless the database already holds data keys.
+func OpenKeyring(db *DB, clk clock.Clock, master *envelope.MasterKey, previous *envelope.MasterKey) (*Keyring, error) {
+	if master == nil {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM encryption_keys").Scan(&count); err != nil {
+			return nil, fmt.Errorf("counting data keys: %w", err)
+		}
+		if count > 0 {
+			return nil, ErrMasterKeyMissing
+		}
+		return nil, nil
+	}
+
+	k := &Keyring{
+		db:       db,
+		clock:    clk,
+		master:   master,
+		previous: previous,
+		keys:     make(map[string][]byte),
+	}
+	if err := k.reload(true); err != nil {
+		return nil, err
+	}
+	if k.activeID == "" {
+		if err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// reload picks up the data keys created by other instances and the newest
+// active key. With rewrap, keys of the previous master key are rewrapped by
+// the current one; that writes, so it must not happen while the caller holds
+// the writer, such as when decrypting the rows of an UPDATE … RETURNING.
+func (k *Keyring) reload(rewrap bool) error {
+	rows, err := k.db.Query(`
+		SELECT id, wrapped_key, wrapped_by, created_at, retired_at
+		FROM encryption_keys
+		ORDER BY created_at ASC, id ASC
+	`)
+	if err != nil {
+		return fmt.Errorf("querying data keys: %w", err)
+	}
+
+	type wrappedKey struct {
+		id, wrapped, wrappedBy string
+		createdAt              time.Time
+		retired                bool
+	}
+	var wrappedKeys []wrappedKey
+	for rows.Next() {
+		var w wrappedKey
+		var retiredAt *time.Time
+		if err := rows.Scan(&w.id, &w.wrapped, &w.wrappedBy, &w.createdAt, &retiredAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning data key: %w", err)
+		}
+		w.retired = retiredAt != nil
+		wrappedKeys = append(wrappedKeys, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("querying data keys: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, w := range wrappedKeys {
+		if _, ok := k.keys[w.id]; !ok || (rewrap && k.previous != nil && w.wrappedBy == k.previous.ID) {
+			key, err := k.unwrap(w.id, w.wrapped, w.wrappedBy, rewrap)
+			if err != nil {
+				return err
+			}
+			k.keys[w.id] = key
+		}
+		if !w.retired {
+			k.activeID = w.id
+			k.activeAt = w.createdAt
+		}
+	}
+
+	return nil
+}
+
+// unwrap opens a data key with the master key that wrapped it. With rewrap,
+// keys of the previous master key are rewrapped by the current one on the
+// way.
+func (k *Keyring) unwrap(id string, wrapped string, wrappedBy string, rewrap bool) ([]byte, error) {
+	switch {
+	case wrappedBy == k.master.ID:
+		key, err := k.master.Unwrap(id, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("unwrapping data key %s: %w", id, err)
+		}
+		return key, nil
+
+	case k.previous != nil && wrappedBy == k.previous.ID:
+		key, err := k.previous.Unwrap(id, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("unwrapping data key %s: %w", id, err)
+		}
+		if !rewrap {
+			return key, nil
+		}
+		rewrapped, err := k.master.Wrap(id, key)
+		if err != nil {
+			return nil, fmt.Errorf("rewrapping data key %s: %w", id, err)
+		}
+		if _, err := k.db.Exec(`
+			UPDATE encryption_keys SET wrapped_key = ?, wrapped_by = ? WHERE id = ? AND wrapped_by = ?
+		`, rewrapped, k.master.ID, id, k.previous.ID); err != nil {
+			return nil, fmt.Errorf("rewrapping data key %s: %w", id, err)
+		}
+		slog.Info("data key rewrapped by the new master key", "key_id", id, "master_key_id", k.master.ID)
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("data key %s is wrapped by master key %s, which isn't set", id, wrappedBy)
+	}
+}
+
+// Rotate creates a new data key and retires the others. Rows sealed with
+// retired keys stay readable and are re-encrypted in the background.
+func (k *Keyring) Rotate() error {
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		return err
+	}
+	id, err := newKeyID()
+	if err != nil {
+		return err
+	}
+	wrapped, err := k.master.Wrap(id, key)
+	if err != nil {
+		return fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	now := k.clock.Now().UTC()
+	tx, err := k.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE encryption_keys SET retired_at = ? WHERE retired_at IS NULL
+	`, now); err != nil {
+		return fmt.Errorf("retiring data keys: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO encryption_keys (id, wrapped_key, wrapped_by, created_at)
+		VALUES (?, ?, ?, ?)
+	`, id, wrapped, k.master.ID, now); err != nil {
+		return fmt.Errorf("inserting data key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing data key: %w", err)
+	}
+
+	k.mu.Lock()
+	k.keys[id] = key
+	k.activeID = id
+	k.activeAt = now
+	k.mu.Unlock()
+
+	slog.Info("data key created", "key_id", id)
+	return nil
+}
+
+// encrypt seals a snapshot with the active key and returns it with the key
+// ID to store next to it
+func (k *Keyring) encrypt(plaintext string) (string, *string, error) {
+	if k == nil {
+		return plaintext, nil, nil
+	}
+
+	k.mu.RLock()
+	id, key := k.activeID, k.keys[k.activeID]
+	k.mu.RUnlock()
+
+	sealed, err := envelope.Seal(key, []byte(plaintext), id)
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypting snapshot: %w", err)
+	}
+	return sealed, &id, nil
+}
+
+// decrypt opens a snapshot sealed with the key named by keyID. Snapshots
+// without a key ID are plain text.
+func (k *Keyring) decrypt(text string, keyID *string) (string, error) {
+	if keyID == nil {
+		return text, nil
+	}
+	if k == nil {
+		return "", ErrMasterKeyMissing
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[*keyID]
+	k.mu.RUnlock()
+	if !ok {
+		// Created by another instance since the keys were loaded
+		if err := k.reload(false); err != nil {
+			return "", err
+		}
+		k.mu.RLock()
+		key, ok = k.keys[*keyID]
+		k.mu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("unknown data key %s", *keyID)
+		}
+	}
+
+	plaintext, err := envelope.Open(key, text, *keyID)
+	if err != nil {
+		return "", fmt.Errorf("decrypting snapshot with key %s: %w", *keyID, err)
+	}
+	return string(plaintext), nil
+}
+
+func (k *Keyring) active() (string, time.Time) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeID, k.activeAt
+}
+
+func newKeyID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating key id: %w", err)
+	}
+	return "dk-" + hex.EncodeToString(b), nil
+}
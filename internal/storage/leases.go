@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClaimDueCapsules atomically leases a batch of due capsules to owner until
+// leaseFor from now, moves them to publishing and returns them. Capsules
+// leased by another owner are skipped until their lease expires, so an
+// instance that crashed mid-batch gives its capsules back once the lease
+// runs out. Every claim is recorded on the timeline of the capsule.
+func (s *CapsuleStore) ClaimDueCapsules(owner string, leaseFor time.Duration) ([]Capsule, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now().UTC()
+	rows, err := tx.Query(`
+		SELECT id FROM capsules
+		WHERE status IN ('pending', 'publishing') AND republish_at <= ? AND collection_id IS NULL
+			AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+			AND (lease_expires_at IS NULL OR lease_expires_at <= ?)
+		ORDER BY republish_at ASC
+		LIMIT ?
+		`+s.db.skipLocked(),
+		now,
+		now,
+		now,
+		capsuleBatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("claiming due capsules: %w", err)
+	}
+
+	var ids []any
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning capsule id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	in := "id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	const lease = "lease_owner = ?, lease_expires_at = ?"
+	expiresAt := now.Add(leaseFor)
+
+	// Left publishing by an instance that crashed, or released once its
+	// republish was posted. Taken over ahead of the pending ones, which
+	// would match once publishing.
+	if _, err := s.setStatuses(tx, STATUS_PUBLISHING, STATUS_PUBLISHING, event{kind: EVENT_CLAIMED, actor: owner, reason: "lease taken over"},
+		in, ids, lease, owner, expiresAt); err != nil {
+		return nil, err
+	}
+	if _, err := s.setStatuses(tx, STATUS_PENDING, STATUS_PUBLISHING, event{kind: EVENT_CLAIMED, actor: owner},
+		in, ids, lease, owner, expiresAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing claim: %w", err)
+	}
+
+	// Read once committed, since decrypting snapshots may read the keyring
+	rows, err = s.db.Query(`
+		SELECT `+capsuleColumns+`
+		FROM capsules
+		WHERE `+in+`
+		ORDER BY republish_at ASC
+	`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("querying claimed capsules: %w", err)
+	}
+	defer rows.Close()
+
+	var capsules []Capsule
+	for rows.Next() {
+		c, err := s.scanCapsule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning capsule: %w", err)
+		}
+		capsules = append(capsules, *c)
+	}
+
+	return capsules, rows.Err()
+}
+
+// ClaimDueCollections atomically leases a batch of due collections to owner,
+// like ClaimDueCapsules
+func (s *CapsuleStore) ClaimDueCollections(owner string, leaseFor time.Duration) ([]Collection, error) {
+	now := s.clock.Now().UTC()
+	rows, err := s.db.Query(`
+		UPDATE collections SET lease_owner = ?, lease_expires_at = ?
+		WHERE id IN (
+			SELECT id FROM collections
+			WHERE status = 'pending' AND reveal_at <= ?
+				AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+				AND (lease_expires_at IS NULL OR lease_expires_at <= ?)
+			ORDER BY reveal_at ASC
+			LIMIT ?
+			`+s.db.skipLocked()+`
+		)
+		RETURNING `+collectionColumns,
+		owner,
+		now.Add(leaseFor),
+		now,
+		now,
+		now,
+		capsuleBatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("claiming due collections: %w", err)
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning collection: %w", err)
+		}
+		collections = append(collections, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].RevealAt.Before(collections[j].RevealAt)
+	})
+
+	return collections, nil
+}
+
+// RenewLeases extends every lease held by owner to leaseFor from now
+func (s *CapsuleStore) RenewLeases(owner string, leaseFor time.Duration) error {
+	expiresAt := s.clock.Now().UTC().Add(leaseFor)
+
+	if _, err := s.db.Exec(`
+		UPDATE capsules SET lease_expires_at = ? WHERE lease_owner = ? AND status = 'publishing'
+	`, expiresAt, owner); err != nil {
+		return fmt.Errorf("renewing capsule leases: %w", err)
+	}
+
+	if _, err := s.db.Exec(`
+		UPDATE collections SET lease_expires_at = ? WHERE lease_owner = ? AND status = 'pending'
+	`, expiresAt, owner); err != nil {
+		return fmt.Errorf("renewing collection leases: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseLeases gives back every lease held by owner, so other instances can
+// pick the work up right away. Capsules still publishing go back to pending,
+// unless their republish was already posted: those stay publishing so the
+// next claim completes them instead of posting them again.
+func (s *CapsuleStore) ReleaseLeases(owner string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.setStatuses(tx, STATUS_PUBLISHING, STATUS_PENDING, event{kind: EVENT_LEASE_RELEASED, actor: owner},
+		"lease_owner = ? AND posted_tweet_id IS NULL", []any{owner},
+		"lease_owner = NULL, lease_expires_at = NULL"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE capsules SET lease_owner = NULL, lease_expires_at = NULL WHERE lease_owner = ?
+	`, owner); err != nil {
+		return fmt.Errorf("releasing capsule leases: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE collections SET lease_owner = NULL, lease_expires_at = NULL WHERE lease_owner = ?
+	`, owner); err != nil {
+		return fmt.Errorf("releasing collection leases: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// CapsulesToVerify returns up to limit pending capsules whose tweet may
+// still be alive, least recently checked first. Capsules checked after
+// checkedBefore are left out, as are tweets already known to be deleted.
+func (s *CapsuleStore) CapsulesToVerify(limit int, checkedBefore time.Time) ([]Capsule, error) {
+	rows, err := s.db.Query(`
+		SELECT `+capsuleColumns+`
+		FROM capsules
+		WHERE status = 'pending' AND (gone_reason IS NULL OR gone_reason != 'deleted')
+			AND (last_checked_at IS NULL OR last_checked_at < ?)
+		ORDER BY last_checked_at IS NOT NULL, last_checked_at ASC, id ASC
+		LIMIT ?
+	`, checkedBefore.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying capsules to verify: %w", err)
+	}
+	defer rows.Close()
+
+	var capsules []Capsule
+	for rows.Next() {
+		c, err := s.scanCapsule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning capsule: %w", err)
+		}
+		capsules = append(capsules, *c)
+	}
+
+	return capsules, rows.Err()
+}
+
+// RecordAlive marks the capsule tweet as seen alive at the given time and
+// refreshes the author handle and display name. A tweet that comes back from
+// being protected is no longer considered gone.
+func (s *CapsuleStore) RecordAlive(id int64, authorHandle string, authorName string, at time.Time) error {
+	if _, err := s.db.Exec(`
+		UPDATE capsules
+		SET last_checked_at = ?, last_seen_alive_at = ?, gone_at = NULL, gone_reason = NULL,
+			tweet_author = CASE WHEN ? != '' THEN ? ELSE tweet_author END,
+			tweet_author_name = CASE WHEN ? != '' THEN ? ELSE tweet_author_name END
+		WHERE id = ?
+	`, at.UTC(), at.UTC(), authorHandle, authorHandle, authorName, authorName, id); err != nil {
+		return fmt.Errorf("recording alive tweet: %w", err)
+	}
+	return nil
+}
+
+// RecordGone marks the capsule tweet as deleted or protected. The time it
+// was first seen gone is kept across later checks.
+func (s *CapsuleStore) RecordGone(id int64, reason string, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Only the first check that finds the tweet gone, or gone for another
+	// reason, makes it to the timeline
+	if err := s.recordEvents(tx, event{
+		kind:   EVENT_TWEET_GONE,
+		actor:  ACTOR_VERIFIER,
+		reason: reason,
+	}, "id = ? AND (gone_reason IS NULL OR gone_reason <> ?)", id, reason); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE capsules
+		SET last_checked_at = ?, gone_at = COALESCE(gone_at, ?), gone_reason = ?
+		WHERE id = ?
+	`, at.UTC(), at.UTC(), reason, id); err != nil {
+		return fmt.Errorf("recording gone tweet: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecordChecked marks the capsule tweet as checked without a verdict, when
+// the lookup didn't say whether it is alive
+func (s *CapsuleStore) RecordChecked(id int64, at time.Time) error {
+	if _, err := s.db.Exec(`
+		UPDATE capsules SET last_checked_at = ? WHERE id = ?
+	`, at.UTC(), id); err != nil {
+		return fmt.Errorf("recording tweet check: %w", err)
+	}
+	return nil
+}
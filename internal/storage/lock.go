@@ -0,0 +1,12 @@
+package storage
+
+import "errors"
+
+// ErrDatabaseInUse is returned when the lock of a SQLite database is held in
+// a way that conflicts with the one asked for
+var ErrDatabaseInUse = errors.New("database is in use")
+
+// LockPath is the lock file guarding the SQLite database at dbPath
+func LockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
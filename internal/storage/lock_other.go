@@ -0,0 +1,15 @@
+//go:build !unix
+
+package storage
+
+// FileLock is a no-op where advisory file locks aren't available; restores
+// there can't tell whether a bot is running
+type FileLock struct{}
+
+func LockDatabase(dbPath string, exclusive bool) (*FileLock, error) {
+	return &FileLock{}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileLock is an advisory lock on the lock file of a SQLite database. Running
+// bots share it; a restore holds it alone.
+type FileLock struct {
+	file *os.File
+}
+
+// LockDatabase takes the lock of the SQLite database at dbPath without
+// waiting. Bots take it shared so several can run side by side; exclusive
+// locks are only granted while no bot runs.
+func LockDatabase(dbPath string, exclusive bool) (*FileLock, error) {
+	file, err := os.OpenFile(LockPath(dbPath), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("locking %s: %w", dbPath, ErrDatabaseInUse)
+		}
+		return nil, fmt.Errorf("locking %s: %w", dbPath, err)
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	return l.file.Close()
+}
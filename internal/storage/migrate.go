@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvsena42/memento/migrations"
+)
+
+// downSuffix ends the file name of the script that undoes a migration
+const downSuffix = ".down.sql"
+
+// ErrMigrationModified is returned when an applied migration no longer
+// matches the checksum recorded when it was applied
+var ErrMigrationModified = errors.New("applied migration was modified")
+
+// Migration is a schema change of the dialect, applied by its up script and
+// undone by its down script when it has one
+type Migration struct {
+	Version  int
+	Name     string // file name of the up script, also the key it is recorded under
+	Up       string
+	Down     string // empty when the migration can't be undone
+	Checksum string // hex SHA-256 of the up script
+}
+
+// MigrationStatus is a migration along with whether and when it was applied.
+// Modified is set when the embedded up script differs from the one applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+	Modified  bool
+}
+
+// Migrations returns the embedded migrations of the dialect, oldest first.
+// Up scripts are named NNN_name.sql and down scripts NNN_name.down.sql.
+func (db *DB) Migrations() ([]Migration, error) {
+	dir := string(db.Dialect)
+	entries, err := fs.ReadDir(migrations.FS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	var list []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" || strings.HasSuffix(name, downSuffix) {
+			continue
+		}
+
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version prefix", name)
+		}
+
+		up, err := fs.ReadFile(migrations.FS, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", name, err)
+		}
+		down, err := fs.ReadFile(migrations.FS, path.Join(dir, strings.TrimSuffix(name, ".sql")+downSuffix))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading down migration of %s: %w", name, err)
+		}
+
+		sum := sha256.Sum256(up)
+		list = append(list, Migration{
+			Version:  version,
+			Name:     name,
+			Up:       string(up),
+			Down:     string(down),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", list[i-1].Name, list[i].Name, list[i].Version)
+		}
+	}
+
+	return list, nil
+}
+
+// MigrationStatus lists every embedded migration with its applied state
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.prepareMigrations(); err != nil {
+		return nil, err
+	}
+
+	list, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(list))
+	for i, m := range list {
+		statuses[i] = MigrationStatus{Migration: m}
+		if record, ok := applied[m.Name]; ok {
+			statuses[i].Applied = true
+			statuses[i].AppliedAt = record.appliedAt
+			statuses[i].Modified = record.checksum != m.Checksum
+		}
+	}
+
+	return statuses, nil
+}
+
+// Migrate applies every pending migration
+func (db *DB) Migrate() error {
+	list, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return db.MigrateTo(list[len(list)-1].Version)
+}
+
+// MigrateDown undoes the latest steps applied migrations
+func (db *DB) MigrateDown(steps int) error {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	target := 0
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		if steps == 0 {
+			target = statuses[i].Version
+			break
+		}
+		steps--
+	}
+
+	return db.MigrateTo(target)
+}
+
+// MigrateTo applies or undoes migrations until the schema is at version:
+// every migration up to it applied and none after it. Each migration runs in
+// its own transaction along with its record in the migrations table. Nothing
+// runs while an applied migration was modified or is missing from the binary.
+func (db *DB) MigrateTo(version int) error {
+	if err := db.prepareMigrations(); err != nil {
+		return err
+	}
+
+	list, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	if err := verifyMigrations(list, applied); err != nil {
+		return err
+	}
+
+	// Refuse before undoing anything if a step down can't be taken
+	for _, m := range list {
+		if _, ok := applied[m.Name]; ok && m.Version > version && m.Down == "" {
+			return fmt.Errorf("migration %s has no down script", m.Name)
+		}
+	}
+
+	// Undo newest first, then apply oldest first
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if _, ok := applied[m.Name]; !ok || m.Version <= version {
+			continue
+		}
+		if err := db.revertMigration(m); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range list {
+		if _, ok := applied[m.Name]; ok {
+			slog.Debug("migration already applied, skipping", "file", m.Name)
+			continue
+		}
+		if m.Version > version {
+			break
+		}
+		if err := db.applyMigration(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) applyMigration(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning migration %s: %w", m.Name, err)
+	}
+	defer tx.Rollback()
+
+	// Scripts run as they are, without rebinding
+	if _, err := tx.Tx.Exec(m.Up); err != nil {
+		return fmt.Errorf("executing migration %s: %w", m.Name, err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO migrations (filename, checksum) VALUES (?, ?)", m.Name, m.Checksum); err != nil {
+		return fmt.Errorf("recording migration %s: %w", m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing migration %s: %w", m.Name, err)
+	}
+
+	slog.Info("migration applied", "file", m.Name)
+	return nil
+}
+
+func (db *DB) revertMigration(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning down migration %s: %w", m.Name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Tx.Exec(m.Down); err != nil {
+		return fmt.Errorf("executing down migration %s: %w", m.Name, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM migrations WHERE filename = ?", m.Name); err != nil {
+		return fmt.Errorf("removing migration record %s: %w", m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing down migration %s: %w", m.Name, err)
+	}
+
+	slog.Info("migration reverted", "file", m.Name)
+	return nil
+}
+
+// prepareMigrations creates the migrations table, and adds the checksum
+// column to tables created before checksums were recorded. Migrations
+// recorded without a checksum take the one of the embedded file.
+func (db *DB) prepareMigrations() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations (
+			filename TEXT PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			checksum TEXT
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	var probe sql.NullString
+	err = db.QueryRow("SELECT checksum FROM migrations WHERE 1 = 0").Scan(&probe)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		if _, err := db.Exec("ALTER TABLE migrations ADD COLUMN checksum TEXT"); err != nil {
+			return fmt.Errorf("adding migration checksums: %w", err)
+		}
+	}
+
+	list, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	var recorded int64
+	for _, m := range list {
+		result, err := db.Exec("UPDATE migrations SET checksum = ? WHERE filename = ? AND checksum IS NULL", m.Checksum, m.Name)
+		if err != nil {
+			return fmt.Errorf("recording checksum of migration %s: %w", m.Name, err)
+		}
+		n, _ := result.RowsAffected()
+		recorded += n
+	}
+	if recorded > 0 {
+		slog.Info("migration checksums recorded", "count", recorded)
+	}
+
+	return nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt *time.Time
+}
+
+func (db *DB) appliedMigrations() (map[string]appliedMigration, error) {
+	rows, err := db.Query("SELECT filename, checksum, applied_at FROM migrations")
+	if err != nil {
+		return nil, fmt.Errorf("querying applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]appliedMigration)
+	for rows.Next() {
+		var filename string
+		var checksum sql.NullString
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&filename, &checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scanning applied migration: %w", err)
+		}
+		record := appliedMigration{checksum: checksum.String}
+		if appliedAt.Valid {
+			record.appliedAt = &appliedAt.Time
+		}
+		applied[filename] = record
+	}
+
+	return applied, rows.Err()
+}
+
+// verifyMigrations checks that every applied migration is still embedded and
+// unchanged since it was applied
+func verifyMigrations(list []Migration, applied map[string]appliedMigration) error {
+	known := make(map[string]bool, len(list))
+	for _, m := range list {
+		known[m.Name] = true
+		if record, ok := applied[m.Name]; ok && record.checksum != m.Checksum {
+			return fmt.Errorf("migration %s: %w", m.Name, ErrMigrationModified)
+		}
+	}
+
+	for filename := range applied {
+		if !known[filename] {
+			return fmt.Errorf("applied migration %s is not embedded in this build", filename)
+		}
+	}
+
+	return nil
+}
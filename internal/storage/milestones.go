@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Milestone is one anniversary at which a capsule is brought back
+type Milestone struct {
+	ID            int64
+	CapsuleID     int64
+	Years         int
+	DueAt         time.Time
+	Status        string
+	PostedTweetID *string
+	PublishedAt   *time.Time
+}
+
+// GetMilestones returns the milestones of a capsule ordered by due time
+func (s *CapsuleStore) GetMilestones(capsuleID int64) ([]Milestone, error) {
+	rows, err := s.db.Query(`
+		SELECT id, capsule_id, years, due_at, status, posted_tweet_id, published_at
+		FROM capsule_milestones
+		WHERE capsule_id = ?
+		ORDER BY due_at ASC
+	`, capsuleID)
+	if err != nil {
+		return nil, fmt.Errorf("querying milestones: %w", err)
+	}
+	defer rows.Close()
+
+	var milestones []Milestone
+	for rows.Next() {
+		var m Milestone
+		if err := rows.Scan(&m.ID, &m.CapsuleID, &m.Years, &m.DueAt, &m.Status, &m.PostedTweetID, &m.PublishedAt); err != nil {
+			return nil, fmt.Errorf("scanning milestone: %w", err)
+		}
+		milestones = append(milestones, m)
+	}
+
+	return milestones, rows.Err()
+}
+
+// NextMilestone returns the earliest pending milestone of a capsule, or nil
+// when the capsule has none left
+func (s *CapsuleStore) NextMilestone(capsuleID int64) (*Milestone, error) {
+	var m Milestone
+	err := s.db.QueryRow(`
+		SELECT id, capsule_id, years, due_at, status, posted_tweet_id, published_at
+		FROM capsule_milestones
+		WHERE capsule_id = ? AND status = 'pending'
+		ORDER BY due_at ASC
+		LIMIT 1
+	`, capsuleID).Scan(&m.ID, &m.CapsuleID, &m.Years, &m.DueAt, &m.Status, &m.PostedTweetID, &m.PublishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting next milestone: %w", err)
+	}
+	return &m, nil
+}
+
+// RecordPosted notes that the republish of a capsule leased to owner was posted as
+// postedTweetID with the given outcome, before CompleteRepublish moves the
+// capsule on. A capsule claimed again with PostedTweetID set only needs
+// completing.
+func (s *CapsuleStore) RecordPosted(id int64, owner string, outcome CapsuleStatus, postedTweetID string) error {
+	result, err := s.db.Exec(`
+		UPDATE capsules SET posted_tweet_id = ?, posted_outcome = ? WHERE id = ? AND status = 'publishing' AND lease_owner = ?
+	`, postedTweetID, outcome, id, owner)
+	if err != nil {
+		return fmt.Errorf("recording posted republish: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: capsule %d is no longer %s by %s", ErrStatusConflict, id, STATUS_PUBLISHING, owner)
+	}
+	return nil
+}
+
+// CompleteRepublish records a successful republish of the capsule. For
+// capsules with milestones it marks the current milestone as published and
+// moves the capsule on to the next one. Otherwise, a non-nil nextOccurrence
+// reschedules a recurring capsule. The capsule only takes the outcome,
+// STATUS_PUBLISHED or STATUS_DELETED_PUBLISHED when the republish was posted
+// from the snapshot of a deleted tweet, once nothing is left to republish.
+func (s *CapsuleStore) CompleteRepublish(id int64, owner string, outcome CapsuleStatus, postedTweetID string, nextOccurrence *time.Time) error {
+	if outcome != STATUS_PUBLISHED && outcome != STATUS_DELETED_PUBLISHED {
+		return fmt.Errorf("%w: a republish can't leave a capsule %s", ErrInvalidTransition, outcome)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now().UTC()
+	published := event{kind: EVENT_PUBLISHED, tweetIDs: []string{postedTweetID}}
+
+	var milestoneID int64
+	var years int
+	err = tx.QueryRow(`
+		SELECT id, years FROM capsule_milestones
+		WHERE capsule_id = ? AND status = 'pending'
+		ORDER BY due_at ASC
+		LIMIT 1
+	`, id).Scan(&milestoneID, &years)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("getting current milestone: %w", err)
+	}
+
+	if err == nil {
+		published.reason = fmt.Sprintf("%d-year milestone", years)
+
+		if _, err := tx.Exec(`
+			UPDATE capsule_milestones SET status = 'published', posted_tweet_id = ?, published_at = ? WHERE id = ?
+		`, postedTweetID, now, milestoneID); err != nil {
+			return fmt.Errorf("updating milestone: %w", err)
+		}
+
+		var nextDue time.Time
+		err = tx.QueryRow(`
+			SELECT due_at FROM capsule_milestones
+			WHERE capsule_id = ? AND status = 'pending'
+			ORDER BY due_at ASC
+			LIMIT 1
+		`, id).Scan(&nextDue)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("getting next milestone: %w", err)
+		}
+
+		if err == nil {
+			published.reason += ", next one due " + nextDue.UTC().Format(time.RFC3339)
+			if err := s.setStatus(tx, id, STATUS_PUBLISHING, STATUS_PENDING, owner, published,
+				"republish_at = ?, attempts = 0, last_error = NULL, next_attempt_at = NULL, lease_owner = NULL, lease_expires_at = NULL, posted_tweet_id = NULL, posted_outcome = NULL",
+				nextDue); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}
+	}
+
+	if nextOccurrence != nil {
+		published.reason = "anniversary, next one due " + nextOccurrence.UTC().Format(time.RFC3339)
+		if err := s.setStatus(tx, id, STATUS_PUBLISHING, STATUS_PENDING, owner, published,
+			"republish_at = ?, published_at = ?, attempts = 0, last_error = NULL, next_attempt_at = NULL, lease_owner = NULL, lease_expires_at = NULL, posted_tweet_id = NULL, posted_outcome = NULL",
+			*nextOccurrence, now); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := s.setStatus(tx, id, STATUS_PUBLISHING, outcome, owner, published,
+		"published_at = ?, next_attempt_at = NULL, lease_owner = NULL, lease_expires_at = NULL, posted_tweet_id = NULL, posted_outcome = NULL", now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DayLoad is the number of republish posts due within one 24 hour window
+type DayLoad struct {
+	Start time.Time
+	Posts int
+}
+
+// RecordPost logs a tweet posted by the bot
+func (s *CapsuleStore) RecordPost(kind string, tweetID string) error {
+	if _, err := s.db.Exec(`
+		INSERT INTO post_log (kind, tweet_id, posted_at) VALUES (?, ?, ?)
+	`, kind, tweetID, s.clock.Now().UTC()); err != nil {
+		return fmt.Errorf("recording post: %w", err)
+	}
+	return nil
+}
+
+// CountPostsSince returns how many tweets the bot posted since the given time
+func (s *CapsuleStore) CountPostsSince(since time.Time) (int, error) {
+	var count int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM post_log WHERE posted_at >= ?
+	`, since).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting posts: %w", err)
+	}
+	return count, nil
+}
+
+// OldestPostSince returns the time of the oldest post made since the given
+// time, or nil when there is none
+func (s *CapsuleStore) OldestPostSince(since time.Time) (*time.Time, error) {
+	var postedAt time.Time
+	err := s.db.QueryRow(`
+		SELECT posted_at FROM post_log WHERE posted_at >= ? ORDER BY posted_at ASC LIMIT 1
+	`, since).Scan(&postedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting oldest post: %w", err)
+	}
+	return &postedAt, nil
+}
+
+// DailyLoadForecast returns how many republish posts fall due in each of the
+// given number of 24 hour windows starting at from: one per pending capsule,
+// and for each pending collection one per member still to post, plus the
+// intro when its thread isn't started yet. Capsules and collections waiting
+// for a retry or deferred count at their next attempt.
+func (s *CapsuleStore) DailyLoadForecast(from time.Time, days int) ([]DayLoad, error) {
+	forecast := make([]DayLoad, days)
+	for i := range forecast {
+		forecast[i].Start = from.Add(time.Duration(i) * 24 * time.Hour)
+	}
+
+	until := from.Add(time.Duration(days) * 24 * time.Hour)
+	add := func(due time.Time, nextAttemptAt *time.Time, posts int) {
+		if nextAttemptAt != nil {
+			due = *nextAttemptAt
+		}
+		day := int(due.Sub(from) / (24 * time.Hour))
+		if day >= 0 && day < days {
+			forecast[day].Posts += posts
+		}
+	}
+
+	rows, err := s.db.Query(`
+		SELECT republish_at, next_attempt_at FROM capsules
+		WHERE status = 'pending' AND collection_id IS NULL
+			AND COALESCE(next_attempt_at, republish_at) >= ? AND COALESCE(next_attempt_at, republish_at) < ?
+	`, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("querying load forecast: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var republishAt time.Time
+		var nextAttemptAt *time.Time
+		if err := rows.Scan(&republishAt, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("scanning load forecast: %w", err)
+		}
+		add(republishAt, nextAttemptAt, 1)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT c.reveal_at, c.next_attempt_at, c.thread_tweet_id,
+			(SELECT COUNT(*) FROM capsules WHERE collection_id = c.id AND status = 'pending')
+		FROM collections c
+		WHERE c.status = 'pending'
+			AND COALESCE(c.next_attempt_at, c.reveal_at) >= ? AND COALESCE(c.next_attempt_at, c.reveal_at) < ?
+	`, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("querying collection load forecast: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revealAt time.Time
+		var nextAttemptAt *time.Time
+		var threadTweetID *string
+		var members int
+		if err := rows.Scan(&revealAt, &nextAttemptAt, &threadTweetID, &members); err != nil {
+			return nil, fmt.Errorf("scanning collection load forecast: %w", err)
+		}
+		if members > 0 && threadTweetID == nil {
+			members++ // the intro
+		}
+		add(revealAt, nextAttemptAt, members)
+	}
+
+	return forecast, rows.Err()
+}
+
+// DeferCapsule postpones a due capsule to until without counting it as a
+// failed attempt, and releases its lease
+func (s *CapsuleStore) DeferCapsule(id int64, owner string, until time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.setStatus(tx, id, STATUS_PUBLISHING, STATUS_PENDING, owner, event{
+		kind:   EVENT_DEFERRED,
+		reason: "post budget exhausted, deferred to " + until.UTC().Format(time.RFC3339),
+	}, "next_attempt_at = ?, lease_owner = NULL, lease_expires_at = NULL", until); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
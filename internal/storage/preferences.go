@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GetTimeZone returns the time zone the user asked their capsules to be
+// delivered in, or an empty string when they never set one
+func (s *CapsuleStore) GetTimeZone(userID string) (string, error) {
+	var timeZone string
+	err := s.db.QueryRow("SELECT time_zone FROM user_preferences WHERE user_id = ?", userID).Scan(&timeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting time zone of %s: %w", userID, err)
+	}
+	return timeZone, nil
+}
+
+func (s *CapsuleStore) SetTimeZone(userID string, timeZone string) error {
+	if _, err := s.db.Exec(`
+		INSERT INTO user_preferences (user_id, time_zone, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET time_zone = excluded.time_zone, updated_at = excluded.updated_at
+	`, userID, timeZone, s.clock.Now().UTC()); err != nil {
+		return fmt.Errorf("setting time zone of %s: %w", userID, err)
+	}
+	return nil
+}
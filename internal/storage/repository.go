@@ -0,0 +1,93 @@
+package storage
+
+import "time"
+
+// CapsuleRepository stores capsules along with their thread parts,
+// milestones, collections, leases and the bookkeeping around publishing them
+type CapsuleRepository interface {
+	Create(c *Capsule) error
+	GetByID(id int64) (*Capsule, error)
+	GetParts(capsuleID int64) ([]CapsulePart, error)
+	TweetAlreadySaved(tweetID string) (bool, error)
+	TweetErased(tweetID string) (bool, error)
+	CountSavedToday(requesterID string) (int, error)
+	CountByStatus() (map[string]int, error)
+	NextRepublishAt() (*time.Time, error)
+	UpdateStatus(id int64, from, to CapsuleStatus, owner string) error
+	ScheduleRetry(id int64, owner string, lastError string, nextAttemptAt time.Time) error
+	RecordFailure(id int64, owner string, status CapsuleStatus, lastError string) error
+	Cancel(requesterID string, tweetID string) (bool, error)
+	CancelRecurring(requesterID string) (int64, error)
+
+	GetMilestones(capsuleID int64) ([]Milestone, error)
+	NextMilestone(capsuleID int64) (*Milestone, error)
+	RecordPosted(id int64, owner string, outcome CapsuleStatus, postedTweetID string) error
+	CompleteRepublish(id int64, owner string, outcome CapsuleStatus, postedTweetID string, nextOccurrence *time.Time) error
+
+	GetOrCreateCollection(ownerID string, ownerHandle string, name string, revealAt time.Time) (*Collection, error)
+	GetCollectionByID(id int64) (*Collection, error)
+	GetCollectionMembers(collectionID int64) ([]Capsule, error)
+	UpdateCollectionStatus(id int64, owner string, status CollectionStatus) error
+	ScheduleCollectionRetry(id int64, owner string, lastError string, nextAttemptAt time.Time) error
+	RecordCollectionFailure(id int64, owner string, status CollectionStatus, lastError string) error
+	StartCollectionThread(id int64, owner string, introTweetID string, total int) error
+	RecordCollectionMember(id int64, owner string, capsuleID int64, status CapsuleStatus, postedTweetID string, lastError string) error
+	DeferCollection(id int64, owner string, until time.Time) error
+
+	ClaimDueCapsules(owner string, leaseFor time.Duration) ([]Capsule, error)
+	ClaimDueCollections(owner string, leaseFor time.Duration) ([]Collection, error)
+	RenewLeases(owner string, leaseFor time.Duration) error
+	ReleaseLeases(owner string) error
+
+	CapsulesToVerify(limit int, checkedBefore time.Time) ([]Capsule, error)
+	RecordAlive(id int64, authorHandle string, authorName string, at time.Time) error
+	RecordGone(id int64, reason string, at time.Time) error
+	RecordChecked(id int64, at time.Time) error
+
+	RecordPost(kind string, tweetID string) error
+	CountPostsSince(since time.Time) (int, error)
+	OldestPostSince(since time.Time) (*time.Time, error)
+	DailyLoadForecast(from time.Time, days int) ([]DayLoad, error)
+	DeferCapsule(id int64, owner string, until time.Time) error
+	RecordDryRunPost(text string, quoteTweetID string, replyToID string) (string, error)
+
+	GetTimeZone(userID string) (string, error)
+	SetTimeZone(userID string, timeZone string) error
+}
+
+// StateRepository keeps small pieces of bot state by key, such as the last
+// mention seen
+type StateRepository interface {
+	GetValue(key string) (string, error)
+	SetValue(key string, value string) error
+}
+
+// KeyRotator rotates the data keys that encrypt tweet snapshots and moves
+// older snapshots onto the active key
+type KeyRotator interface {
+	EncryptionEnabled() bool
+	RotateDataKey(maxAge time.Duration) (bool, error)
+	ReencryptBatch(limit int) (int, error)
+}
+
+// Migrator moves a database schema between versions of its migrations
+type Migrator interface {
+	Migrate() error
+	MigrateTo(version int) error
+	MigrateDown(steps int) error
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// Store is everything the bot keeps in the database
+type Store interface {
+	CapsuleRepository
+	StateRepository
+	KeyRotator
+}
+
+// CapsuleStore implements Store on SQLite and PostgreSQL alike, through the
+// dialect of its DB
+var (
+	_ Store    = (*CapsuleStore)(nil)
+	_ Migrator = (*DB)(nil)
+)
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEmptyQuery is returned by Search for a query without any term
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+// SearchResult is a capsule matching a search, with an excerpt of its
+// snapshot where the terms matched, between [ and ]
+type SearchResult struct {
+	Capsule
+	Snippet string
+	Score   float64 // higher is a better match
+}
+
+// Search finds the capsules matching every term of query in their snapshot,
+// thread, author or requester handle, best match first. A term ending in *
+// matches as a prefix. Snapshots encrypted at rest are not indexed, so they
+// only match on handles.
+func (s *CapsuleStore) Search(query string, filter CapsuleFilter, limit int) ([]SearchResult, error) {
+	if strings.TrimSpace(strings.ReplaceAll(query, "*", "")) == "" {
+		return nil, ErrEmptyQuery
+	}
+
+	where, args := filter.where()
+	var sqlQuery string
+	if s.db.Dialect == Postgres {
+		sqlQuery = `
+			SELECT ` + capsuleColumns + `,
+				ts_headline('english', CASE WHEN key_id IS NULL THEN tweet_text ELSE '' END, q,
+					'StartSel=[, StopSel=], MaxWords=16, MinWords=6'),
+				ts_rank(search_vector, q)
+			FROM capsules, to_tsquery('english', ?) q
+			WHERE search_vector @@ q` + where + `
+			ORDER BY ts_rank(search_vector, q) DESC, id DESC
+			LIMIT ?
+		`
+		args = append([]any{tsQuery(query)}, args...)
+	} else {
+		sqlQuery = `
+			SELECT ` + capsuleColumns + `,
+				snippet(capsules_fts, -1, '[', ']', '…', 16),
+				-bm25(capsules_fts, 10.0, 5.0, 2.0, 2.0, 1.0)
+			FROM capsules_fts
+			JOIN capsules ON capsules.id = capsules_fts.rowid
+			WHERE capsules_fts MATCH ?` + where + `
+			ORDER BY bm25(capsules_fts, 10.0, 5.0, 2.0, 2.0, 1.0), id DESC
+			LIMIT ?
+		`
+		args = append([]any{ftsQuery(query)}, args...)
+	}
+
+	rows, err := s.db.Query(sqlQuery, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("searching capsules: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		c, err := s.scanCapsule(withColumns{rows, []any{&r.Snippet, &r.Score}})
+		if err != nil {
+			return nil, fmt.Errorf("scanning search result: %w", err)
+		}
+		r.Capsule = *c
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// EncryptedCapsules counts the capsules matching filter whose snapshot is
+// encrypted at rest. Their text is left out of the search index, so Search
+// only finds them by their handles.
+func (s *CapsuleStore) EncryptedCapsules(filter CapsuleFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM capsules WHERE key_id IS NOT NULL"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting encrypted capsules: %w", err)
+	}
+	return count, nil
+}
+
+// withColumns scans a row of capsuleColumns followed by extra columns
+type withColumns struct {
+	row   scanner
+	extra []any
+}
+
+func (w withColumns) Scan(dest ...any) error {
+	return w.row.Scan(append(dest, w.extra...)...)
+}
+
+// searchTerms splits a query into words, and tells which end in * to match
+// as a prefix
+func searchTerms(query string) (terms []string, prefix []bool) {
+	for _, field := range strings.Fields(query) {
+		term := strings.TrimRight(field, "*")
+		if term == "" {
+			continue
+		}
+		terms = append(terms, term)
+		prefix = append(prefix, len(term) < len(field))
+	}
+	return terms, prefix
+}
+
+// ftsQuery quotes every term of a query so FTS5 reads it as text rather than
+// its query syntax
+func ftsQuery(query string) string {
+	terms, prefix := searchTerms(query)
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix[i] {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// tsQuery is the to_tsquery form of ftsQuery: every term is quoted as a
+// phrase and the terms are ANDed
+func tsQuery(query string) string {
+	terms, prefix := searchTerms(query)
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = "'" + strings.ReplaceAll(strings.ReplaceAll(term, `\`, `\\`), "'", `\'`) + "'"
+		if prefix[i] {
+			quoted[i] += ":*"
+		}
+	}
+	return strings.Join(quoted, " & ")
+}
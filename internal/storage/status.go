@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// CapsuleStatus is where a capsule is in its life. A capsule waits as
+// pending, is publishing while an instance holds its lease, and ends in one
+// of the terminal statuses. Capsules with milestones or anniversaries go back
+// to pending between republishes.
+type CapsuleStatus string
+
+const (
+	STATUS_PENDING           CapsuleStatus = "pending"
+	STATUS_PUBLISHING        CapsuleStatus = "publishing"
+	STATUS_PUBLISHED         CapsuleStatus = "published"
+	STATUS_DELETED_PUBLISHED CapsuleStatus = "deleted_published" // republished from the snapshot, the tweet being gone
+	STATUS_FAILED            CapsuleStatus = "failed"            // a terminal publish error
+	STATUS_DEAD              CapsuleStatus = "dead"              // out of publish attempts
+	STATUS_CANCELLED         CapsuleStatus = "cancelled"
+)
+
+// capsuleTransitions lists the statuses each status can move to. Members of
+// a collection are published with it, so they skip publishing. A publishing
+// capsule whose lease expired or was released after its republish was posted
+// is claimed again, staying publishing.
+var capsuleTransitions = map[CapsuleStatus][]CapsuleStatus{
+	STATUS_PENDING:    {STATUS_PUBLISHING, STATUS_CANCELLED, STATUS_PUBLISHED, STATUS_DELETED_PUBLISHED, STATUS_FAILED},
+	STATUS_PUBLISHING: {STATUS_PUBLISHING, STATUS_PENDING, STATUS_PUBLISHED, STATUS_DELETED_PUBLISHED, STATUS_FAILED, STATUS_DEAD},
+}
+
+// CollectionStatus is where a collection is in its life. A collection waits
+// as pending, also while an instance holds its lease and posts its thread or
+// waits for another attempt, and ends published, failed or dead.
+type CollectionStatus string
+
+const (
+	COLLECTION_PENDING   CollectionStatus = "pending"
+	COLLECTION_PUBLISHED CollectionStatus = "published"
+	COLLECTION_FAILED    CollectionStatus = "failed" // a terminal publish error
+	COLLECTION_DEAD      CollectionStatus = "dead"   // out of publish attempts
+)
+
+// collectionTransitions lists the statuses each collection status can move to
+var collectionTransitions = map[CollectionStatus][]CollectionStatus{
+	COLLECTION_PENDING: {COLLECTION_PUBLISHED, COLLECTION_FAILED, COLLECTION_DEAD},
+}
+
+// ErrInvalidTransition is returned for a status change the transition table
+// doesn't allow
+var ErrInvalidTransition = errors.New("invalid capsule status transition")
+
+// ErrStatusConflict is returned when a capsule is no longer in the status a
+// change expected, because another instance or request changed it first
+var ErrStatusConflict = errors.New("capsule status changed concurrently")
+
+// Valid reports whether s is a known status
+func (s CapsuleStatus) Valid() bool {
+	switch s {
+	case STATUS_PENDING, STATUS_PUBLISHING, STATUS_PUBLISHED, STATUS_DELETED_PUBLISHED, STATUS_FAILED, STATUS_DEAD, STATUS_CANCELLED:
+		return true
+	}
+	return false
+}
+
+// Terminal reports whether nothing can happen to a capsule in s anymore
+func (s CapsuleStatus) Terminal() bool {
+	return s.Valid() && len(capsuleTransitions[s]) == 0
+}
+
+// CanTransitionTo reports whether a capsule in s may move to status to
+func (s CapsuleStatus) CanTransitionTo(to CapsuleStatus) bool {
+	return slices.Contains(capsuleTransitions[s], to)
+}
+
+// Valid reports whether s is a known collection status
+func (s CollectionStatus) Valid() bool {
+	switch s {
+	case COLLECTION_PENDING, COLLECTION_PUBLISHED, COLLECTION_FAILED, COLLECTION_DEAD:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether a collection in s may move to status to
+func (s CollectionStatus) CanTransitionTo(to CollectionStatus) bool {
+	return slices.Contains(collectionTransitions[s], to)
+}
+
+// setStatus moves capsule id from one status to another, recording e on its
+// timeline and applying the extra assignments of set along the way. The
+// update only applies while the capsule is still in from and, when from is
+// STATUS_PUBLISHING, still leased to owner. It fails with ErrStatusConflict
+// otherwise, so an instance whose lease expired can't complete a capsule
+// another instance has claimed since.
+func (s *CapsuleStore) setStatus(tx *Tx, id int64, from, to CapsuleStatus, owner string, e event, set string, args ...any) error {
+	where := "id = ?"
+	whereArgs := []any{id}
+	if from == STATUS_PUBLISHING {
+		where += " AND lease_owner = ?"
+		whereArgs = append(whereArgs, owner)
+	}
+
+	n, err := s.setStatuses(tx, from, to, e, where, whereArgs, set, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if from == STATUS_PUBLISHING {
+			return fmt.Errorf("%w: capsule %d is no longer %s by %s", ErrStatusConflict, id, from, owner)
+		}
+		return fmt.Errorf("%w: capsule %d is no longer %s", ErrStatusConflict, id, from)
+	}
+	return nil
+}
+
+// setStatuses moves every capsule in from that matches where to status to,
+// recording e on their timelines and applying the extra assignments of set.
+// It returns how many capsules were moved.
+func (s *CapsuleStore) setStatuses(tx *Tx, from, to CapsuleStatus, e event, where string, whereArgs []any, set string, args ...any) (int, error) {
+	if !from.CanTransitionTo(to) {
+		return 0, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	where = "status = ? AND (" + where + ")"
+	whereArgs = append([]any{string(from)}, whereArgs...)
+
+	e.toStatus = string(to)
+	if err := s.recordEvents(tx, e, where, whereArgs...); err != nil {
+		return 0, err
+	}
+
+	if set != "" {
+		set = ", " + set
+	}
+	result, err := tx.Exec(`UPDATE capsules SET status = ?`+set+` WHERE `+where,
+		append(append([]any{string(to)}, args...), whereArgs...)...)
+	if err != nil {
+		return 0, fmt.Errorf("updating capsule status: %w", err)
+	}
+	return rowsAffected(result), nil
+}
@@ -0,0 +1,795 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/envelope"
+)
+
+// POSTGRES_TEST_URL_ENV names the PostgreSQL database the conformance suite
+// runs against besides SQLite. Each test migrates a schema of its own there
+// and drops it afterwards.
+const POSTGRES_TEST_URL_ENV = "MEMENTO_TEST_POSTGRES_URL"
+
+// conformanceStart is the time the virtual clock of every case starts at
+var conformanceStart = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// storeBackend opens a migrated database for one test
+type storeBackend struct {
+	name string
+	open func(t *testing.T) *DB
+}
+
+var storeBackends = []storeBackend{
+	{name: "sqlite", open: openSQLiteTestDB},
+	{name: "postgres", open: openPostgresTestDB},
+}
+
+// storeCase is a behaviour every Store implementation must share
+type storeCase struct {
+	name string
+	run  func(t *testing.T, s Store, clk *clock.Virtual)
+}
+
+// TestStoreConformance runs every case against every backend, each on a
+// fresh database
+func TestStoreConformance(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, c := range storeCases {
+				t.Run(c.name, func(t *testing.T) {
+					db := backend.open(t)
+					clk := clock.NewVirtual(conformanceStart)
+					keys, err := OpenKeyring(db, clk, envelope.NewMasterKey(make([]byte, 32)), nil)
+					if err != nil {
+						t.Fatalf("opening keyring: %v", err)
+					}
+					c.run(t, NewCapsuleStore(db, clk, keys, []byte("conformance erasure key")), clk)
+				})
+			}
+		})
+	}
+}
+
+func openSQLiteTestDB(t *testing.T) *DB {
+	db, err := New(filepath.Join(t.TempDir(), "memento.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return db
+}
+
+// openPostgresTestDB migrates a schema of its own in the database named by
+// POSTGRES_TEST_URL_ENV, so tests never see each other's rows
+func openPostgresTestDB(t *testing.T) *DB {
+	dsn := os.Getenv(POSTGRES_TEST_URL_ENV)
+	if dsn == "" {
+		t.Skipf("%s is not set", POSTGRES_TEST_URL_ENV)
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "memento_test_" + strings.ToLower(rand.Text())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	db, err := Open(dsn + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return db
+}
+
+var storeCases = []storeCase{
+	{"creates and finds capsules", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{
+			TweetID:     "100",
+			TweetText:   "first part",
+			IsThread:    true,
+			Parts:       []CapsulePart{{Position: 1, TweetID: "101", TweetText: "second part"}},
+			RepublishAt: clk.Now().Add(time.Hour),
+		})
+
+		got := getCapsule(t, s, c.ID)
+		if got.TweetText != "first part" || got.Status != STATUS_PENDING || !got.IsThread {
+			t.Errorf("got %q %s thread=%v, want the saved pending thread", got.TweetText, got.Status, got.IsThread)
+		}
+		if !got.RepublishAt.Equal(c.RepublishAt) {
+			t.Errorf("republish at %v, want %v", got.RepublishAt, c.RepublishAt)
+		}
+		parts, err := s.GetParts(c.ID)
+		if err != nil {
+			t.Fatalf("getting parts: %v", err)
+		}
+		if len(parts) != 1 || parts[0].TweetText != "second part" {
+			t.Errorf("parts %+v, want the saved one", parts)
+		}
+
+		if missing, err := s.GetByID(c.ID + 1); err != nil || missing != nil {
+			t.Errorf("GetByID of a missing capsule = %v, %v, want nil, nil", missing, err)
+		}
+		if saved, err := s.TweetAlreadySaved("100"); err != nil || !saved {
+			t.Errorf("TweetAlreadySaved(100) = %v, %v, want true", saved, err)
+		}
+		if saved, err := s.TweetAlreadySaved("200"); err != nil || saved {
+			t.Errorf("TweetAlreadySaved(200) = %v, %v, want false", saved, err)
+		}
+
+		err = s.Create(&Capsule{RequesterID: "requester", TweetID: "100", RepublishAt: clk.Now()})
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("saving a tweet twice: %v, want ErrDuplicate", err)
+		}
+
+		if n, err := s.CountSavedToday("requester"); err != nil || n != 1 {
+			t.Errorf("CountSavedToday = %d, %v, want 1", n, err)
+		}
+		clk.Advance(24 * time.Hour)
+		if n, err := s.CountSavedToday("requester"); err != nil || n != 0 {
+			t.Errorf("CountSavedToday the next day = %d, %v, want 0", n, err)
+		}
+		expectCounts(t, s, map[string]int{"pending": 1})
+	}},
+
+	{"claims due capsules once", func(t *testing.T, s Store, clk *clock.Virtual) {
+		due := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		createCapsule(t, s, &Capsule{TweetID: "200", RepublishAt: clk.Now().Add(time.Hour)})
+
+		expectClaim(t, s, "a", due.ID)
+		expectClaim(t, s, "b")
+		if c := getCapsule(t, s, due.ID); c.Status != STATUS_PUBLISHING {
+			t.Errorf("claimed capsule is %s, want publishing", c.Status)
+		}
+
+		// An instance that stops renewing its lease gives the capsule up
+		clk.Advance(2 * time.Minute)
+		expectClaim(t, s, "b", due.ID)
+		if err := s.RenewLeases("b", time.Minute); err != nil {
+			t.Fatalf("renewing leases: %v", err)
+		}
+		clk.Advance(30 * time.Second)
+		if err := s.RenewLeases("b", time.Minute); err != nil {
+			t.Fatalf("renewing leases: %v", err)
+		}
+		clk.Advance(45 * time.Second)
+		expectClaim(t, s, "a")
+	}},
+
+	{"completes a posted republish", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", c.ID)
+
+		if err := s.RecordPosted(c.ID, "a", STATUS_PUBLISHED, "900"); err != nil {
+			t.Fatalf("recording posted republish: %v", err)
+		}
+		got := getCapsule(t, s, c.ID)
+		if got.PostedTweetID == nil || *got.PostedTweetID != "900" || got.PostedOutcome == nil || *got.PostedOutcome != STATUS_PUBLISHED {
+			t.Errorf("posted %v %v, want 900 published", got.PostedTweetID, got.PostedOutcome)
+		}
+
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_PUBLISHED, "900", nil); err != nil {
+			t.Fatalf("completing republish: %v", err)
+		}
+		got = getCapsule(t, s, c.ID)
+		if got.Status != STATUS_PUBLISHED || got.PublishedAt == nil || got.PostedTweetID != nil {
+			t.Errorf("completed capsule is %s published at %v posted %v, want published and cleared", got.Status, got.PublishedAt, got.PostedTweetID)
+		}
+		expectCounts(t, s, map[string]int{"published": 1})
+
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_FAILED, "900", nil); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("completing as failed: %v, want ErrInvalidTransition", err)
+		}
+	}},
+
+	{"rejects an expired lease owner", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", c.ID)
+		clk.Advance(2 * time.Minute)
+		expectClaim(t, s, "b", c.ID)
+
+		if err := s.RecordPosted(c.ID, "a", STATUS_PUBLISHED, "900"); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("recording posted by a: %v, want ErrStatusConflict", err)
+		}
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_PUBLISHED, "900", nil); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("completing by a: %v, want ErrStatusConflict", err)
+		}
+		if err := s.ScheduleRetry(c.ID, "a", "boom", clk.Now().Add(time.Hour)); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("scheduling a retry by a: %v, want ErrStatusConflict", err)
+		}
+		if err := s.RecordFailure(c.ID, "a", STATUS_FAILED, "boom"); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("recording a failure by a: %v, want ErrStatusConflict", err)
+		}
+		if err := s.DeferCapsule(c.ID, "a", clk.Now().Add(time.Hour)); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("deferring by a: %v, want ErrStatusConflict", err)
+		}
+
+		if err := s.CompleteRepublish(c.ID, "b", STATUS_PUBLISHED, "901", nil); err != nil {
+			t.Errorf("completing by b: %v", err)
+		}
+	}},
+
+	{"releases leases", func(t *testing.T, s Store, clk *clock.Virtual) {
+		posted := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		unposted := createCapsule(t, s, &Capsule{TweetID: "200", RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", posted.ID, unposted.ID)
+
+		if err := s.RecordPosted(posted.ID, "a", STATUS_PUBLISHED, "900"); err != nil {
+			t.Fatalf("recording posted republish: %v", err)
+		}
+		if err := s.ReleaseLeases("a"); err != nil {
+			t.Fatalf("releasing leases: %v", err)
+		}
+
+		// The posted capsule waits for the next claim to complete it
+		if c := getCapsule(t, s, posted.ID); c.Status != STATUS_PUBLISHING {
+			t.Errorf("released posted capsule is %s, want publishing", c.Status)
+		}
+		if c := getCapsule(t, s, unposted.ID); c.Status != STATUS_PENDING {
+			t.Errorf("released capsule is %s, want pending", c.Status)
+		}
+
+		capsules := expectClaim(t, s, "b", posted.ID, unposted.ID)
+		if capsules[0].PostedTweetID == nil || *capsules[0].PostedTweetID != "900" {
+			t.Errorf("reclaimed posted capsule lost its republish: %v", capsules[0].PostedTweetID)
+		}
+	}},
+
+	{"retries and fails capsules", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", c.ID)
+
+		retryAt := clk.Now().Add(time.Hour)
+		if err := s.ScheduleRetry(c.ID, "a", "rate limited", retryAt); err != nil {
+			t.Fatalf("scheduling retry: %v", err)
+		}
+		got := getCapsule(t, s, c.ID)
+		if got.Status != STATUS_PENDING || got.Attempts != 1 || got.LastError == nil || *got.LastError != "rate limited" {
+			t.Errorf("retried capsule is %s after %d attempts with %v", got.Status, got.Attempts, got.LastError)
+		}
+		if got.NextAttemptAt == nil || !got.NextAttemptAt.Equal(retryAt) {
+			t.Errorf("next attempt at %v, want %v", got.NextAttemptAt, retryAt)
+		}
+		expectNextRepublish(t, s, retryAt)
+		expectClaim(t, s, "a")
+
+		clk.Set(retryAt)
+		expectClaim(t, s, "a", c.ID)
+		if err := s.RecordFailure(c.ID, "a", STATUS_PUBLISHED, "boom"); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("recording a failure as published: %v, want ErrInvalidTransition", err)
+		}
+		if err := s.RecordFailure(c.ID, "a", STATUS_FAILED, "tweet too long"); err != nil {
+			t.Fatalf("recording failure: %v", err)
+		}
+		got = getCapsule(t, s, c.ID)
+		if got.Status != STATUS_FAILED || got.Attempts != 2 {
+			t.Errorf("failed capsule is %s after %d attempts, want failed after 2", got.Status, got.Attempts)
+		}
+		expectNextRepublish(t, s, time.Time{})
+	}},
+
+	{"enforces the transition table", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+
+		if err := s.UpdateStatus(c.ID, STATUS_PENDING, STATUS_DEAD, ""); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("pending to dead: %v, want ErrInvalidTransition", err)
+		}
+		if err := s.UpdateStatus(c.ID, STATUS_PUBLISHING, STATUS_PENDING, "a"); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("publishing to pending of a pending capsule: %v, want ErrStatusConflict", err)
+		}
+		if err := s.UpdateStatus(c.ID, STATUS_PENDING, STATUS_FAILED, ""); err != nil {
+			t.Fatalf("pending to failed: %v", err)
+		}
+		if err := s.UpdateStatus(c.ID, STATUS_FAILED, STATUS_PENDING, ""); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("failed to pending: %v, want ErrInvalidTransition", err)
+		}
+		if c := getCapsule(t, s, c.ID); c.Status != STATUS_FAILED {
+			t.Errorf("capsule is %s, want failed", c.Status)
+		}
+	}},
+
+	{"cancels pending capsules", func(t *testing.T, s Store, clk *clock.Virtual) {
+		once := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now().Add(time.Hour)})
+		createCapsule(t, s, &Capsule{TweetID: "200", Recurring: true, RepublishAt: clk.Now().Add(time.Hour)})
+		createCapsule(t, s, &Capsule{TweetID: "300", Recurring: true, RepublishAt: clk.Now().Add(time.Hour)})
+		createCapsule(t, s, &Capsule{RequesterID: "someone-else", TweetID: "400", Recurring: true, RepublishAt: clk.Now().Add(time.Hour)})
+
+		if ok, err := s.Cancel("someone-else", "100"); err != nil || ok {
+			t.Errorf("cancelling another requester's capsule = %v, %v, want false", ok, err)
+		}
+		if ok, err := s.Cancel("requester", "100"); err != nil || !ok {
+			t.Errorf("Cancel = %v, %v, want true", ok, err)
+		}
+		if ok, err := s.Cancel("requester", "100"); err != nil || ok {
+			t.Errorf("cancelling twice = %v, %v, want false", ok, err)
+		}
+		if c := getCapsule(t, s, once.ID); c.Status != STATUS_CANCELLED {
+			t.Errorf("cancelled capsule is %s", c.Status)
+		}
+
+		if n, err := s.CancelRecurring("requester"); err != nil || n != 2 {
+			t.Errorf("CancelRecurring = %d, %v, want 2", n, err)
+		}
+		expectCounts(t, s, map[string]int{"cancelled": 3, "pending": 1})
+	}},
+
+	{"moves through milestones", func(t *testing.T, s Store, clk *clock.Virtual) {
+		first := clk.Now()
+		second := first.AddDate(1, 0, 0)
+		c := createCapsule(t, s, &Capsule{
+			TweetID:     "100",
+			RepublishAt: first,
+			Milestones:  []Milestone{{Years: 1, DueAt: first}, {Years: 2, DueAt: second}},
+		})
+
+		expectClaim(t, s, "a", c.ID)
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_PUBLISHED, "901", nil); err != nil {
+			t.Fatalf("completing first milestone: %v", err)
+		}
+		got := getCapsule(t, s, c.ID)
+		if got.Status != STATUS_PENDING || !got.RepublishAt.Equal(second) {
+			t.Errorf("capsule is %s due %v, want pending due %v", got.Status, got.RepublishAt, second)
+		}
+		next, err := s.NextMilestone(c.ID)
+		if err != nil || next == nil || next.Years != 2 {
+			t.Fatalf("NextMilestone = %+v, %v, want the 2-year one", next, err)
+		}
+
+		clk.Set(second)
+		expectClaim(t, s, "a", c.ID)
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_PUBLISHED, "902", nil); err != nil {
+			t.Fatalf("completing second milestone: %v", err)
+		}
+		if got := getCapsule(t, s, c.ID); got.Status != STATUS_PUBLISHED {
+			t.Errorf("capsule is %s after its last milestone, want published", got.Status)
+		}
+		if next, err := s.NextMilestone(c.ID); err != nil || next != nil {
+			t.Errorf("NextMilestone = %+v, %v, want none", next, err)
+		}
+
+		milestones, err := s.GetMilestones(c.ID)
+		if err != nil {
+			t.Fatalf("getting milestones: %v", err)
+		}
+		for i, m := range milestones {
+			want := fmt.Sprint(901 + i)
+			if m.Status != "published" || m.PostedTweetID == nil || *m.PostedTweetID != want {
+				t.Errorf("milestone %d is %s posted %v, want published as %s", m.Years, m.Status, m.PostedTweetID, want)
+			}
+		}
+	}},
+
+	{"reschedules recurring capsules", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", Recurring: true, RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", c.ID)
+
+		next := clk.Now().AddDate(1, 0, 0)
+		if err := s.CompleteRepublish(c.ID, "a", STATUS_PUBLISHED, "900", &next); err != nil {
+			t.Fatalf("completing republish: %v", err)
+		}
+		got := getCapsule(t, s, c.ID)
+		if got.Status != STATUS_PENDING || !got.RepublishAt.Equal(next) || got.PublishedAt == nil {
+			t.Errorf("capsule is %s due %v published at %v, want pending due %v", got.Status, got.RepublishAt, got.PublishedAt, next)
+		}
+		expectNextRepublish(t, s, next)
+	}},
+
+	{"defers capsules", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now()})
+		expectClaim(t, s, "a", c.ID)
+
+		until := clk.Now().Add(6 * time.Hour)
+		if err := s.DeferCapsule(c.ID, "a", until); err != nil {
+			t.Fatalf("deferring capsule: %v", err)
+		}
+		if got := getCapsule(t, s, c.ID); got.Status != STATUS_PENDING || got.Attempts != 0 {
+			t.Errorf("deferred capsule is %s after %d attempts, want pending after none", got.Status, got.Attempts)
+		}
+		expectNextRepublish(t, s, until)
+		expectClaim(t, s, "b")
+
+		forecast, err := s.DailyLoadForecast(clk.Now(), 2)
+		if err != nil {
+			t.Fatalf("forecasting load: %v", err)
+		}
+		if len(forecast) != 2 || forecast[0].Posts != 1 || forecast[1].Posts != 0 {
+			t.Errorf("forecast %+v, want the deferred capsule on the first day", forecast)
+		}
+
+		clk.Set(until)
+		expectClaim(t, s, "b", c.ID)
+	}},
+
+	{"publishes collections", func(t *testing.T, s Store, clk *clock.Virtual) {
+		revealAt := clk.Now().Add(time.Hour)
+		col, err := s.GetOrCreateCollection("requester", "Requester", "Trip", revealAt)
+		if err != nil {
+			t.Fatalf("creating collection: %v", err)
+		}
+		again, err := s.GetOrCreateCollection("requester", "Requester", "TRIP", revealAt.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("getting collection: %v", err)
+		}
+		if again.ID != col.ID || !again.RevealAt.Equal(revealAt) {
+			t.Errorf("got collection %d revealed at %v, want %d at %v", again.ID, again.RevealAt, col.ID, revealAt)
+		}
+
+		var members []int64
+		for i := range 3 {
+			m := createCapsule(t, s, &Capsule{TweetID: fmt.Sprint(100 + i), CollectionID: &col.ID, RepublishAt: revealAt})
+			members = append(members, m.ID)
+		}
+		got, err := s.GetCollectionMembers(col.ID)
+		if err != nil {
+			t.Fatalf("getting members: %v", err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("%d members, want 3", len(got))
+		}
+		expectForecast(t, s, clk.Now(), 4)
+
+		clk.Set(revealAt)
+		expectClaim(t, s, "a")
+		expectCollectionClaim(t, s, "a", col.ID)
+		expectCollectionClaim(t, s, "b")
+
+		if err := s.StartCollectionThread(col.ID, "a", "800", 3); err != nil {
+			t.Fatalf("starting thread: %v", err)
+		}
+		if err := s.RecordCollectionMember(col.ID, "a", members[0], STATUS_PUBLISHED, "801", ""); err != nil {
+			t.Fatalf("recording member: %v", err)
+		}
+		if err := s.RecordCollectionMember(col.ID, "b", members[1], STATUS_PUBLISHED, "802", ""); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("recording a member by b: %v, want ErrStatusConflict", err)
+		}
+
+		// Cut short by the post budget, the thread resumes where it stopped
+		resumeAt := clk.Now().Add(2 * time.Hour)
+		if err := s.DeferCollection(col.ID, "a", resumeAt); err != nil {
+			t.Fatalf("deferring collection: %v", err)
+		}
+		expectCollectionClaim(t, s, "b")
+		expectNextRepublish(t, s, resumeAt)
+		expectForecast(t, s, clk.Now(), 2)
+
+		clk.Set(resumeAt)
+		claimed := expectCollectionClaim(t, s, "b", col.ID)
+		c := claimed[0]
+		if c.ThreadTweetID == nil || *c.ThreadTweetID != "801" || c.ThreadTotal == nil || *c.ThreadTotal != 3 || c.ThreadPosted != 1 {
+			t.Errorf("resumed thread at %v of %v with %d posted, want 801 of 3 with 1", c.ThreadTweetID, c.ThreadTotal, c.ThreadPosted)
+		}
+
+		// A retryable failure is retried later, still resuming the thread
+		retryAt := clk.Now().Add(time.Hour)
+		if err := s.ScheduleCollectionRetry(col.ID, "b", "service unavailable", retryAt); err != nil {
+			t.Fatalf("scheduling collection retry: %v", err)
+		}
+		expectCollectionClaim(t, s, "b")
+		clk.Set(retryAt)
+		claimed = expectCollectionClaim(t, s, "b", col.ID)
+		if c := claimed[0]; c.Attempts != 1 || c.LastError == nil || *c.LastError != "service unavailable" || c.ThreadPosted != 1 {
+			t.Errorf("retried collection has %d attempts, error %v and %d posted, want 1, service unavailable and 1", c.Attempts, c.LastError, c.ThreadPosted)
+		}
+
+		if err := s.RecordCollectionMember(col.ID, "b", members[1], STATUS_FAILED, "", "tweet too long"); err != nil {
+			t.Fatalf("recording failed member: %v", err)
+		}
+		if err := s.UpdateCollectionStatus(col.ID, "b", COLLECTION_PENDING); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("ending a collection pending: %v, want ErrInvalidTransition", err)
+		}
+		if err := s.RecordCollectionFailure(col.ID, "b", COLLECTION_PUBLISHED, "boom"); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("publishing a collection as a failure: %v, want ErrInvalidTransition", err)
+		}
+		if err := s.UpdateCollectionStatus(col.ID, "a", COLLECTION_PUBLISHED); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("publishing a collection by a: %v, want ErrStatusConflict", err)
+		}
+		if err := s.UpdateCollectionStatus(col.ID, "b", COLLECTION_PUBLISHED); err != nil {
+			t.Fatalf("publishing collection: %v", err)
+		}
+		if err := s.RecordCollectionFailure(col.ID, "b", COLLECTION_FAILED, "boom"); !errors.Is(err, ErrStatusConflict) {
+			t.Errorf("failing a published collection: %v, want ErrStatusConflict", err)
+		}
+
+		final, err := s.GetCollectionByID(col.ID)
+		if err != nil {
+			t.Fatalf("getting collection: %v", err)
+		}
+		if final.Status != COLLECTION_PUBLISHED || final.PublishedAt == nil {
+			t.Errorf("collection is %s published at %v", final.Status, final.PublishedAt)
+		}
+		wants := []CapsuleStatus{STATUS_PUBLISHED, STATUS_FAILED, STATUS_FAILED}
+		for i, id := range members {
+			if got := getCapsule(t, s, id); got.Status != wants[i] {
+				t.Errorf("member %d is %s, want %s", i+1, got.Status, wants[i])
+			}
+		}
+		if got := getCapsule(t, s, members[2]); got.LastError == nil || *got.LastError != "not posted in the collection thread" {
+			t.Errorf("leftover member failed with %v", got.LastError)
+		}
+	}},
+
+	{"tracks tweet liveness", func(t *testing.T, s Store, clk *clock.Virtual) {
+		alive := createCapsule(t, s, &Capsule{TweetID: "100", RepublishAt: clk.Now().Add(time.Hour)})
+		gone := createCapsule(t, s, &Capsule{TweetID: "200", RepublishAt: clk.Now().Add(time.Hour)})
+
+		capsules, err := s.CapsulesToVerify(10, clk.Now())
+		if err != nil {
+			t.Fatalf("querying capsules to verify: %v", err)
+		}
+		if len(capsules) != 2 {
+			t.Fatalf("%d capsules to verify, want 2", len(capsules))
+		}
+
+		if err := s.RecordAlive(alive.ID, "renamed", "Renamed", clk.Now()); err != nil {
+			t.Fatalf("recording alive: %v", err)
+		}
+		if err := s.RecordGone(gone.ID, "deleted", clk.Now()); err != nil {
+			t.Fatalf("recording gone: %v", err)
+		}
+		got := getCapsule(t, s, alive.ID)
+		if got.TweetAuthor != "renamed" || got.TweetAuthorName != "Renamed" || got.LastSeenAliveAt == nil {
+			t.Errorf("alive capsule by %s (%s) seen at %v", got.TweetAuthor, got.TweetAuthorName, got.LastSeenAliveAt)
+		}
+		got = getCapsule(t, s, gone.ID)
+		if got.GoneReason == nil || *got.GoneReason != "deleted" || got.GoneAt == nil || !got.GoneAt.Equal(clk.Now()) {
+			t.Errorf("gone capsule gone at %v for %v", got.GoneAt, got.GoneReason)
+		}
+
+		clk.Advance(time.Hour)
+		if err := s.RecordChecked(alive.ID, clk.Now()); err != nil {
+			t.Fatalf("recording check: %v", err)
+		}
+		capsules, err = s.CapsulesToVerify(10, clk.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("querying capsules to verify: %v", err)
+		}
+		if len(capsules) != 1 || capsules[0].ID != alive.ID {
+			t.Errorf("capsules to verify %v, want only the alive one", capsuleIDs(capsules))
+		}
+		if capsules, err := s.CapsulesToVerify(10, clk.Now()); err != nil || len(capsules) != 0 {
+			t.Errorf("capsules checked just now to verify: %v, %v, want none", capsuleIDs(capsules), err)
+		}
+	}},
+
+	{"logs posts", func(t *testing.T, s Store, clk *clock.Virtual) {
+		since := clk.Now()
+		if oldest, err := s.OldestPostSince(since); err != nil || oldest != nil {
+			t.Errorf("OldestPostSince with no posts = %v, %v, want nil", oldest, err)
+		}
+
+		first := clk.Now()
+		for i := range 3 {
+			if err := s.RecordPost("republish", fmt.Sprint(900+i)); err != nil {
+				t.Fatalf("recording post: %v", err)
+			}
+			clk.Advance(time.Hour)
+		}
+
+		if n, err := s.CountPostsSince(since); err != nil || n != 3 {
+			t.Errorf("CountPostsSince = %d, %v, want 3", n, err)
+		}
+		if n, err := s.CountPostsSince(since.Add(90 * time.Minute)); err != nil || n != 1 {
+			t.Errorf("CountPostsSince 90 minutes later = %d, %v, want 1", n, err)
+		}
+		if oldest, err := s.OldestPostSince(since); err != nil || oldest == nil || !oldest.Equal(first) {
+			t.Errorf("OldestPostSince = %v, %v, want %v", oldest, err, first)
+		}
+	}},
+
+	{"records dry-run posts", func(t *testing.T, s Store, clk *clock.Virtual) {
+		first, err := s.RecordDryRunPost("Saved!", "", "100")
+		if err != nil {
+			t.Fatalf("recording dry-run post: %v", err)
+		}
+		second, err := s.RecordDryRunPost("Republished", "100", "")
+		if err != nil {
+			t.Fatalf("recording dry-run post: %v", err)
+		}
+		if !strings.HasPrefix(first, DRY_RUN_ID_PREFIX) || !strings.HasPrefix(second, DRY_RUN_ID_PREFIX) || first == second {
+			t.Errorf("dry-run posts got IDs %q and %q", first, second)
+		}
+		if erased, err := s.TweetErased("100"); err != nil || erased {
+			t.Errorf("TweetErased = %v, %v, want false", erased, err)
+		}
+	}},
+
+	{"keeps state and preferences", func(t *testing.T, s Store, clk *clock.Virtual) {
+		if value, err := s.GetValue("last_mention_id"); err != nil || value != "" {
+			t.Errorf("GetValue of a missing key = %q, %v, want empty", value, err)
+		}
+		for _, value := range []string{"100", "200"} {
+			if err := s.SetValue("last_mention_id", value); err != nil {
+				t.Fatalf("setting value: %v", err)
+			}
+		}
+		if value, err := s.GetValue("last_mention_id"); err != nil || value != "200" {
+			t.Errorf("GetValue = %q, %v, want 200", value, err)
+		}
+
+		if tz, err := s.GetTimeZone("requester"); err != nil || tz != "" {
+			t.Errorf("GetTimeZone of a new user = %q, %v, want empty", tz, err)
+		}
+		for _, tz := range []string{"Europe/Lisbon", "America/Sao_Paulo"} {
+			if err := s.SetTimeZone("requester", tz); err != nil {
+				t.Fatalf("setting time zone: %v", err)
+			}
+		}
+		if tz, err := s.GetTimeZone("requester"); err != nil || tz != "America/Sao_Paulo" {
+			t.Errorf("GetTimeZone = %q, %v, want America/Sao_Paulo", tz, err)
+		}
+	}},
+
+	{"rotates data keys", func(t *testing.T, s Store, clk *clock.Virtual) {
+		c := createCapsule(t, s, &Capsule{
+			TweetID:     "100",
+			TweetText:   "first part",
+			Parts:       []CapsulePart{{Position: 1, TweetID: "101", TweetText: "second part"}},
+			RepublishAt: clk.Now(),
+		})
+
+		if !s.EncryptionEnabled() {
+			t.Fatal("encryption is off with a keyring")
+		}
+		if rotated, err := s.RotateDataKey(24 * time.Hour); err != nil || rotated {
+			t.Errorf("rotating a fresh key = %v, %v, want false", rotated, err)
+		}
+		if n, err := s.ReencryptBatch(10); err != nil || n != 0 {
+			t.Errorf("re-encrypting under the active key = %d, %v, want 0", n, err)
+		}
+
+		clk.Advance(25 * time.Hour)
+		if rotated, err := s.RotateDataKey(24 * time.Hour); err != nil || !rotated {
+			t.Errorf("rotating an old key = %v, %v, want true", rotated, err)
+		}
+		if n, err := s.ReencryptBatch(10); err != nil || n != 2 {
+			t.Errorf("re-encrypting after rotation = %d, %v, want 2", n, err)
+		}
+		if n, err := s.ReencryptBatch(10); err != nil || n != 0 {
+			t.Errorf("re-encrypting again = %d, %v, want 0", n, err)
+		}
+
+		if got := getCapsule(t, s, c.ID); got.TweetText != "first part" {
+			t.Errorf("re-encrypted capsule says %q", got.TweetText)
+		}
+		parts, err := s.GetParts(c.ID)
+		if err != nil {
+			t.Fatalf("getting parts: %v", err)
+		}
+		if len(parts) != 1 || parts[0].TweetText != "second part" {
+			t.Errorf("re-encrypted parts %+v", parts)
+		}
+	}},
+}
+
+// createCapsule saves c on behalf of "requester" unless it names another
+func createCapsule(t *testing.T, s Store, c *Capsule) *Capsule {
+	t.Helper()
+	if c.RequesterID == "" {
+		c.RequesterID = "requester"
+		c.RequesterHandle = "requester"
+	}
+	if c.TweetAuthor == "" {
+		c.TweetAuthor = "author"
+	}
+	if err := s.Create(c); err != nil {
+		t.Fatalf("creating capsule: %v", err)
+	}
+	return c
+}
+
+func getCapsule(t *testing.T, s Store, id int64) *Capsule {
+	t.Helper()
+	c, err := s.GetByID(id)
+	if err != nil {
+		t.Fatalf("getting capsule %d: %v", id, err)
+	}
+	if c == nil {
+		t.Fatalf("capsule %d not found", id)
+	}
+	return c
+}
+
+func capsuleIDs(capsules []Capsule) []int64 {
+	ids := make([]int64, len(capsules))
+	for i, c := range capsules {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// expectClaim claims due capsules for owner with a one-minute lease and
+// checks it got exactly ids, in order
+func expectClaim(t *testing.T, s Store, owner string, ids ...int64) []Capsule {
+	t.Helper()
+	capsules, err := s.ClaimDueCapsules(owner, time.Minute)
+	if err != nil {
+		t.Fatalf("claiming capsules for %s: %v", owner, err)
+	}
+	if got := capsuleIDs(capsules); fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("%s claimed capsules %v, want %v", owner, got, ids)
+	}
+	return capsules
+}
+
+func expectCollectionClaim(t *testing.T, s Store, owner string, ids ...int64) []Collection {
+	t.Helper()
+	collections, err := s.ClaimDueCollections(owner, time.Minute)
+	if err != nil {
+		t.Fatalf("claiming collections for %s: %v", owner, err)
+	}
+	got := make([]int64, len(collections))
+	for i, c := range collections {
+		got[i] = c.ID
+	}
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("%s claimed collections %v, want %v", owner, got, ids)
+	}
+	return collections
+}
+
+// expectNextRepublish checks NextRepublishAt, which is nil for a zero want
+func expectNextRepublish(t *testing.T, s Store, want time.Time) {
+	t.Helper()
+	next, err := s.NextRepublishAt()
+	if err != nil {
+		t.Fatalf("getting next republish time: %v", err)
+	}
+	switch {
+	case want.IsZero() && next != nil:
+		t.Errorf("next republish at %v, want none", next)
+	case !want.IsZero() && (next == nil || !next.Equal(want)):
+		t.Errorf("next republish at %v, want %v", next, want)
+	}
+}
+
+// expectForecast checks that want republish posts fall due within a day of from
+func expectForecast(t *testing.T, s Store, from time.Time, want int) {
+	t.Helper()
+	forecast, err := s.DailyLoadForecast(from, 1)
+	if err != nil {
+		t.Fatalf("forecasting load: %v", err)
+	}
+	if got := forecast[0].Posts; got != want {
+		t.Errorf("%d posts due within a day of %v, want %d", got, from, want)
+	}
+}
+
+func expectCounts(t *testing.T, s Store, want map[string]int) {
+	t.Helper()
+	counts, err := s.CountByStatus()
+	if err != nil {
+		t.Fatalf("counting capsules: %v", err)
+	}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("capsules by status %v, want %v", counts, want)
+	}
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jvsena42/memento/internal/clock"
+	"github.com/jvsena42/memento/internal/envelope"
+)
+
+const (
+	stressPollers   = 6
+	stressMentions  = 40 // capsules created by each poller
+	stressInstances = 2  // bot instances sharing the database file
+)
+
+// TestConcurrentWrites runs the write paths of the poller, the scheduler,
+// the verifier and the re-encrypter of two instances sharing one SQLite
+// file, and checks that no SQLITE_BUSY reaches the callers and no write is
+// lost.
+func TestConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memento.db")
+	master := envelope.NewMasterKey(make([]byte, 32))
+
+	stores := make([]*CapsuleStore, stressInstances)
+	for i := range stores {
+		db, err := New(path)
+		if err != nil {
+			t.Fatalf("opening instance %d: %v", i, err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if i == 0 {
+			if err := db.Migrate(); err != nil {
+				t.Fatalf("migrating: %v", err)
+			}
+		}
+		keys, err := OpenKeyring(db, clock.System, master, nil)
+		if err != nil {
+			t.Fatalf("opening keyring of instance %d: %v", i, err)
+		}
+		stores[i] = NewCapsuleStore(db, clock.System, keys, nil)
+	}
+
+	var (
+		errs      = make(chan error, 1024)
+		created   atomic.Int64
+		completed atomic.Int64
+		checked   sync.Map
+		pollers   sync.WaitGroup
+		workers   sync.WaitGroup
+	)
+	total := int64(stressPollers * stressMentions)
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	for p := range stressPollers {
+		s := stores[p%stressInstances]
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			for m := range stressMentions {
+				tweetID := fmt.Sprintf("%d%04d", p+1, m)
+				err := s.Create(&Capsule{
+					RequesterID:     fmt.Sprintf("requester-%d", p),
+					RequesterHandle: fmt.Sprintf("requester%d", p),
+					TweetID:         tweetID,
+					TweetAuthor:     "author",
+					TweetText:       "snapshot of " + tweetID,
+					Parts:           []CapsulePart{{Position: 1, TweetID: tweetID + "1", TweetText: "part of " + tweetID}},
+					RepublishAt:     time.Now().Add(-time.Minute),
+				})
+				if err != nil {
+					report(fmt.Errorf("creating capsule: %w", err))
+					continue
+				}
+				created.Add(1)
+				if _, err := s.RecordDryRunPost("Saved!", "", tweetID); err != nil {
+					report(fmt.Errorf("recording dry-run reply: %w", err))
+				}
+				if err := s.SetValue(fmt.Sprintf("last_mention_%d", p), tweetID); err != nil {
+					report(fmt.Errorf("setting last mention: %w", err))
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	for i, s := range stores {
+		owner := fmt.Sprintf("instance-%d", i)
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer func() {
+				if err := s.ReleaseLeases(owner); err != nil {
+					report(fmt.Errorf("releasing leases: %w", err))
+				}
+			}()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				capsules, err := s.ClaimDueCapsules(owner, time.Minute)
+				if err != nil {
+					report(fmt.Errorf("claiming capsules: %w", err))
+					continue
+				}
+				for _, c := range capsules {
+					postedID := "posted-" + c.TweetID
+					if err := s.RecordPosted(c.ID, owner, STATUS_PUBLISHED, postedID); err != nil {
+						report(fmt.Errorf("recording posted capsule %d: %w", c.ID, err))
+					}
+					if err := s.CompleteRepublish(c.ID, owner, STATUS_PUBLISHED, postedID, nil); err != nil {
+						report(fmt.Errorf("completing capsule %d: %w", c.ID, err))
+						continue
+					}
+					if err := s.RecordPost("republish", postedID); err != nil {
+						report(fmt.Errorf("recording post: %w", err))
+					}
+					completed.Add(1)
+				}
+				if err := s.RenewLeases(owner, time.Minute); err != nil {
+					report(fmt.Errorf("renewing leases: %w", err))
+				}
+			}
+		}()
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				capsules, err := s.CapsulesToVerify(10, time.Now())
+				if err != nil {
+					report(fmt.Errorf("querying capsules to verify: %w", err))
+					continue
+				}
+				for n, c := range capsules {
+					switch n % 3 {
+					case 0:
+						err = s.RecordAlive(c.ID, "author", "Author", time.Now())
+					case 1:
+						err = s.RecordGone(c.ID, "protected", time.Now())
+					default:
+						err = s.RecordChecked(c.ID, time.Now())
+					}
+					if err != nil {
+						report(fmt.Errorf("recording check of capsule %d: %w", c.ID, err))
+						continue
+					}
+					checked.Store(c.ID, true)
+				}
+			}
+		}()
+	}
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		for rotations := 0; ; rotations++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if rotations%20 == 0 {
+				if err := stores[0].keys.Rotate(); err != nil {
+					report(fmt.Errorf("rotating data key: %w", err))
+				}
+			}
+			if _, err := stores[0].ReencryptBatch(25); err != nil {
+				report(fmt.Errorf("re-encrypting: %w", err))
+			}
+		}
+	}()
+
+	pollers.Wait()
+	deadline := time.Now().Add(time.Minute)
+	for completed.Load() < created.Load() && len(errs) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	workers.Wait()
+	close(errs)
+
+	for err := range errs {
+		if isBusy(err) {
+			t.Errorf("SQLITE_BUSY reached the caller: %v", err)
+		} else {
+			t.Error(err)
+		}
+	}
+	if completed.Load() < created.Load() {
+		t.Errorf("only %d of %d capsules completed", completed.Load(), created.Load())
+	}
+	if t.Failed() {
+		return
+	}
+
+	s := stores[0]
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("counting: %v", err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM capsules"); n != int(total) {
+		t.Errorf("%d capsules, want %d", n, total)
+	}
+	if n := count("SELECT COUNT(*) FROM capsules WHERE status = 'published'"); n != int(total) {
+		t.Errorf("%d capsules published, want %d", n, total)
+	}
+	if n := count("SELECT COUNT(*) FROM capsule_parts"); n != int(total) {
+		t.Errorf("%d thread parts, want %d", n, total)
+	}
+	if n := count("SELECT COUNT(*) FROM capsule_events WHERE event = ?", EVENT_PUBLISHED); n != int(total) {
+		t.Errorf("%d published events, want %d", n, total)
+	}
+	if n := count("SELECT COUNT(*) FROM post_log"); n != int(total) {
+		t.Errorf("%d posts logged, want %d", n, total)
+	}
+	if n := count("SELECT COUNT(*) FROM dry_run_posts"); n != int(total) {
+		t.Errorf("%d dry-run posts, want %d", n, total)
+	}
+	for p := range stressPollers {
+		value, err := s.GetValue(fmt.Sprintf("last_mention_%d", p))
+		if err != nil {
+			t.Fatalf("getting last mention: %v", err)
+		}
+		if want := fmt.Sprintf("%d%04d", p+1, stressMentions-1); value != want {
+			t.Errorf("last mention of poller %d is %q, want %q", p, value, want)
+		}
+	}
+	checked.Range(func(id, _ any) bool {
+		if n := count("SELECT COUNT(*) FROM capsules WHERE id = ? AND last_checked_at IS NOT NULL", id); n != 1 {
+			t.Errorf("check of capsule %d was lost", id)
+		}
+		return true
+	})
+
+	// Every snapshot still decrypts to what was saved, whichever key it ended under
+	for {
+		n, err := s.ReencryptBatch(100)
+		if err != nil {
+			t.Fatalf("re-encrypting: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	for id := int64(1); id <= total; id++ {
+		c, err := s.GetByID(id)
+		if err != nil {
+			t.Fatalf("getting capsule %d: %v", id, err)
+		}
+		if want := "snapshot of " + c.TweetID; c.TweetText != want {
+			t.Errorf("capsule %d says %q, want %q", id, c.TweetText, want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CapsuleFilter narrows the capsules of an export, import or search. Zero
+// fields match everything.
+type CapsuleFilter struct {
+	Statuses  []CapsuleStatus
+	Requester string     // requester ID, or handle with or without the @
+	From      *time.Time // created at or after
+	To        *time.Time // created before
+}
+
+// Matches reports whether the capsule passes the filter
+func (f CapsuleFilter) Matches(c *Capsule) bool {
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, c.Status) {
+		return false
+	}
+	if f.Requester != "" && c.RequesterID != f.Requester &&
+		!strings.EqualFold(c.RequesterHandle, strings.TrimPrefix(f.Requester, "@")) {
+		return false
+	}
+	if f.From != nil && c.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !c.CreatedAt.Before(*f.To) {
+		return false
+	}
+	return true
+}
+
+func (f CapsuleFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+	if len(f.Statuses) > 0 {
+		conditions = append(conditions, "status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, status := range f.Statuses {
+			args = append(args, string(status))
+		}
+	}
+	if f.Requester != "" {
+		conditions = append(conditions, "(requester_id = ? OR LOWER(requester_handle) = LOWER(?))")
+		args = append(args, f.Requester, strings.TrimPrefix(f.Requester, "@"))
+	}
+	if f.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if f.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// ExportCapsules calls fn for every capsule matching the filter, oldest
+// first, with its parts and milestones loaded and the collection it belongs
+// to, if any. Capsules are read in batches so the export streams.
+func (s *CapsuleStore) ExportCapsules(filter CapsuleFilter, fn func(c *Capsule, collection *Collection) error) error {
+	where, args := filter.where()
+	collections := make(map[int64]*Collection)
+
+	var lastID int64
+	for {
+		batch, err := s.capsulesAfter(lastID, where, args)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for i := range batch {
+			c := &batch[i]
+			lastID = c.ID
+
+			if c.Parts, err = s.GetParts(c.ID); err != nil {
+				return err
+			}
+			if c.Milestones, err = s.GetMilestones(c.ID); err != nil {
+				return err
+			}
+
+			var collection *Collection
+			if c.CollectionID != nil {
+				collection = collections[*c.CollectionID]
+				if collection == nil {
+					if collection, err = s.GetCollectionByID(*c.CollectionID); err != nil {
+						return err
+					}
+					collections[*c.CollectionID] = collection
+				}
+			}
+
+			if err := fn(c, collection); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *CapsuleStore) capsulesAfter(lastID int64, where string, args []any) ([]Capsule, error) {
+	rows, err := s.db.Query(`
+		SELECT `+capsuleColumns+`
+		FROM capsules
+		WHERE id > ?`+where+`
+		ORDER BY id ASC
+		LIMIT ?
+	`, append(append([]any{lastID}, args...), capsuleBatchSize)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying capsules to export: %w", err)
+	}
+	defer rows.Close()
+
+	var capsules []Capsule
+	for rows.Next() {
+		c, err := s.scanCapsule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning capsule: %w", err)
+		}
+		capsules = append(capsules, *c)
+	}
+
+	return capsules, rows.Err()
+}
+
+// ImportCapsule inserts an exported capsule as it was, with its status,
+// parts and milestones, into the given collection. Capsules are keyed by
+// tweet ID: it returns false and changes nothing when the tweet is already
+// saved or was erased. Leases are not carried over, so a capsule exported
+// while publishing comes back pending, unless its republish was posted
+// already: that one stays publishing and is completed on its next claim
+// without being posted again.
+func (s *CapsuleStore) ImportCapsule(c *Capsule, collection *Collection) (bool, error) {
+	status := c.Status
+	switch status {
+	case STATUS_PUBLISHING:
+		if c.PostedTweetID == nil {
+			status = STATUS_PENDING
+		}
+	case "deleted": // exports older than migration 018
+		status = STATUS_DELETED_PUBLISHED
+	}
+	if !status.Valid() {
+		return false, fmt.Errorf("importing capsule: unknown status %q", status)
+	}
+	if c.PostedOutcome != nil && *c.PostedOutcome != STATUS_PUBLISHED && *c.PostedOutcome != STATUS_DELETED_PUBLISHED {
+		return false, fmt.Errorf("importing capsule: unknown posted outcome %q", *c.PostedOutcome)
+	}
+	if collection != nil && !collection.Status.Valid() {
+		return false, fmt.Errorf("importing capsule: unknown collection status %q", collection.Status)
+	}
+
+	text, keyID, err := s.keys.encrypt(c.TweetText)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Erased tweets are never brought back, nor capsules of a requester
+	// that predate their erasure
+	erased, err := tweetErased(tx, c.TweetID)
+	if err != nil {
+		return false, err
+	}
+	if !erased {
+		erased, err = requesterErased(tx, s.erasureKey, c.RequesterID, c.CreatedAt)
+		if err != nil {
+			return false, err
+		}
+	}
+	if erased {
+		return false, nil
+	}
+
+	var collectionID *int64
+	if collection != nil {
+		id, err := importCollection(tx, collection)
+		if err != nil {
+			return false, err
+		}
+		collectionID = &id
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO capsules (requester_id, requester_handle, tweet_id, tweet_author, tweet_author_id, tweet_author_name, tweet_text, is_reply, is_thread,
+			recurring, recur_until, collection_id, edit_history_ids, editable_until, edits_remaining,
+			attempts, last_error, next_attempt_at, delivery_tz, last_checked_at, last_seen_alive_at, gone_at, gone_reason,
+			created_at, republish_at, status, published_at, key_id, posted_tweet_id, posted_outcome)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tweet_id) DO NOTHING
+		RETURNING id
+	`, c.RequesterID, c.RequesterHandle, c.TweetID, c.TweetAuthor, c.TweetAuthorID, c.TweetAuthorName, text, c.IsReply, c.IsThread,
+		c.Recurring, utcOrNil(c.RecurUntil), collectionID, strings.Join(c.EditHistoryIDs, ","), utcOrNil(c.EditableUntil), c.EditsRemaining,
+		c.Attempts, c.LastError, utcOrNil(c.NextAttemptAt), c.DeliveryTZ, utcOrNil(c.LastCheckedAt), utcOrNil(c.LastSeenAliveAt), utcOrNil(c.GoneAt), c.GoneReason,
+		c.CreatedAt.UTC(), c.RepublishAt.UTC(), string(status), utcOrNil(c.PublishedAt), keyID, c.PostedTweetID, c.PostedOutcome).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("inserting capsule: %w", err)
+	}
+
+	if err := s.insertParts(tx, id, c.Parts); err != nil {
+		return false, err
+	}
+
+	if err := s.recordEvents(tx, event{
+		kind:     EVENT_IMPORTED,
+		actor:    ACTOR_IMPORT,
+		tweetIDs: []string{c.TweetID},
+	}, "id = ?", id); err != nil {
+		return false, err
+	}
+
+	for _, m := range c.Milestones {
+		if _, err := tx.Exec(`
+			INSERT INTO capsule_milestones (capsule_id, years, due_at, status, posted_tweet_id, published_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, id, m.Years, m.DueAt.UTC(), m.Status, m.PostedTweetID, utcOrNil(m.PublishedAt)); err != nil {
+			return false, fmt.Errorf("inserting capsule milestone: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("committing capsule: %w", err)
+	}
+	c.ID = id
+
+	return true, nil
+}
+
+// importCollection returns the ID of the collection matching an exported
+// one, creating it if needed, with its thread so far. Pending collections
+// merge with the owner's open collection of the same name; others match on
+// their creation time.
+func importCollection(tx *Tx, c *Collection) (int64, error) {
+	var id int64
+	if c.Status == COLLECTION_PENDING {
+		if _, err := tx.Exec(`
+			INSERT INTO collections (owner_id, owner_handle, name, created_at, reveal_at,
+				thread_tweet_id, thread_total, thread_posted, attempts, last_error, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (owner_id, name) WHERE status = 'pending' DO NOTHING
+		`, c.OwnerID, c.OwnerHandle, c.Name, c.CreatedAt.UTC(), c.RevealAt.UTC(),
+			c.ThreadTweetID, c.ThreadTotal, c.ThreadPosted, c.Attempts, c.LastError, utcOrNil(c.NextAttemptAt)); err != nil {
+			return 0, fmt.Errorf("inserting collection: %w", err)
+		}
+		err := tx.QueryRow(`
+			SELECT id FROM collections WHERE owner_id = ? AND name = ? AND status = 'pending'
+		`, c.OwnerID, c.Name).Scan(&id)
+		if err != nil {
+			return 0, fmt.Errorf("getting collection: %w", err)
+		}
+		return id, nil
+	}
+
+	err := tx.QueryRow(`
+		SELECT id FROM collections WHERE owner_id = ? AND name = ? AND created_at = ?
+	`, c.OwnerID, c.Name, c.CreatedAt.UTC()).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("getting collection: %w", err)
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO collections (owner_id, owner_handle, name, created_at, reveal_at, status, published_at,
+			thread_tweet_id, thread_total, thread_posted, attempts, last_error, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, c.OwnerID, c.OwnerHandle, c.Name, c.CreatedAt.UTC(), c.RevealAt.UTC(), string(c.Status), utcOrNil(c.PublishedAt),
+		c.ThreadTweetID, c.ThreadTotal, c.ThreadPosted, c.Attempts, c.LastError, utcOrNil(c.NextAttemptAt)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("inserting collection: %w", err)
+	}
+	return id, nil
+}
+
+// ListValues returns every key of the key_value table
+func (s *CapsuleStore) ListValues() (map[string]string, error) {
+	rows, err := s.db.Query("SELECT key, value FROM key_value ORDER BY key")
+	if err != nil {
+		return nil, fmt.Errorf("querying values: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("scanning value: %w", err)
+		}
+		values[key] = value
+	}
+
+	return values, rows.Err()
+}
+
+// SetValueIfMissing sets a key unless it already has a value, and reports
+// whether it did
+func (s *CapsuleStore) SetValueIfMissing(key string, value string) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO key_value (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO NOTHING
+	`, key, value)
+	if err != nil {
+		return false, fmt.Errorf("error setting value %s: %w", key, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error setting value %s: %w", key, err)
+	}
+	return n > 0, nil
+}
+
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,214 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns is the header of a CSV export. Every row repeats the format
+// version in its first column. Parts, milestones and the collection are
+// JSON, edit history IDs are comma-separated and missing values are empty.
+var csvColumns = []string{
+	"format_version", "tweet_id", "requester_id", "requester_handle", "tweet_author", "tweet_author_id", "tweet_author_name", "tweet_text",
+	"is_reply", "is_thread", "recurring", "recur_until", "edit_history_ids", "editable_until", "edits_remaining",
+	"attempts", "last_error", "next_attempt_at", "delivery_tz", "last_checked_at", "last_seen_alive_at", "gone_at", "gone_reason",
+	"created_at", "republish_at", "status", "published_at", "posted_tweet_id", "posted_outcome", "parts", "milestones", "collection",
+}
+
+func (r *capsuleRecord) csvRow() ([]string, error) {
+	parts, err := jsonColumn(r.Parts)
+	if err != nil {
+		return nil, err
+	}
+	milestones, err := jsonColumn(r.Milestones)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := jsonColumn(r.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		strconv.Itoa(FORMAT_VERSION), r.TweetID, r.RequesterID, r.RequesterHandle, r.TweetAuthor, r.TweetAuthorID, r.TweetAuthorName, r.TweetText,
+		strconv.FormatBool(r.IsReply), strconv.FormatBool(r.IsThread), strconv.FormatBool(r.Recurring), timeColumn(r.RecurUntil),
+		strings.Join(r.EditHistoryIDs, ","), timeColumn(r.EditableUntil), intColumn(r.EditsRemaining),
+		strconv.Itoa(r.Attempts), stringColumn(r.LastError), timeColumn(r.NextAttemptAt), r.DeliveryTZ,
+		timeColumn(r.LastCheckedAt), timeColumn(r.LastSeenAliveAt), timeColumn(r.GoneAt), stringColumn(r.GoneReason),
+		timeColumn(&r.CreatedAt), timeColumn(&r.RepublishAt), r.Status, timeColumn(r.PublishedAt),
+		stringColumn(r.PostedTweetID), stringColumn(r.PostedOutcome), parts, milestones, collection,
+	}, nil
+}
+
+// csvRow is a row of a CSV export read by column name, so columns may come
+// in any order. The first bad value is kept in err.
+type csvRow struct {
+	index  map[string]int
+	values []string
+	err    error
+}
+
+func (row *csvRow) get(column string) string {
+	i, ok := row.index[column]
+	if !ok || i >= len(row.values) {
+		return ""
+	}
+	return row.values[i]
+}
+
+func (row *csvRow) fail(column string, err error) {
+	if row.err == nil {
+		row.err = fmt.Errorf("column %s: %w", column, err)
+	}
+}
+
+func (row *csvRow) bool(column string) bool {
+	v := row.get(column)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		row.fail(column, err)
+	}
+	return b
+}
+
+func (row *csvRow) int(column string) int {
+	v := row.get(column)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		row.fail(column, err)
+	}
+	return n
+}
+
+func (row *csvRow) optionalInt(column string) *int {
+	if row.get(column) == "" {
+		return nil
+	}
+	n := row.int(column)
+	return &n
+}
+
+func (row *csvRow) optionalString(column string) *string {
+	v := row.get(column)
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+func (row *csvRow) time(column string) time.Time {
+	t := row.optionalTime(column)
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func (row *csvRow) optionalTime(column string) *time.Time {
+	v := row.get(column)
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		row.fail(column, err)
+		return nil
+	}
+	return &t
+}
+
+func (row *csvRow) json(column string, v any) {
+	if raw := row.get(column); raw != "" {
+		if err := json.Unmarshal([]byte(raw), v); err != nil {
+			row.fail(column, err)
+		}
+	}
+}
+
+func (row *csvRow) capsuleRecord() (*capsuleRecord, error) {
+	if version := row.int("format_version"); version < 1 || version > FORMAT_VERSION {
+		return nil, fmt.Errorf("unsupported format version %d", version)
+	}
+
+	r := &capsuleRecord{
+		TweetID:         row.get("tweet_id"),
+		RequesterID:     row.get("requester_id"),
+		RequesterHandle: row.get("requester_handle"),
+		TweetAuthor:     row.get("tweet_author"),
+		TweetAuthorID:   row.get("tweet_author_id"),
+		TweetAuthorName: row.get("tweet_author_name"),
+		TweetText:       row.get("tweet_text"),
+		IsReply:         row.bool("is_reply"),
+		IsThread:        row.bool("is_thread"),
+		Recurring:       row.bool("recurring"),
+		RecurUntil:      row.optionalTime("recur_until"),
+		EditableUntil:   row.optionalTime("editable_until"),
+		EditsRemaining:  row.optionalInt("edits_remaining"),
+		Attempts:        row.int("attempts"),
+		LastError:       row.optionalString("last_error"),
+		NextAttemptAt:   row.optionalTime("next_attempt_at"),
+		DeliveryTZ:      row.get("delivery_tz"),
+		LastCheckedAt:   row.optionalTime("last_checked_at"),
+		LastSeenAliveAt: row.optionalTime("last_seen_alive_at"),
+		GoneAt:          row.optionalTime("gone_at"),
+		GoneReason:      row.optionalString("gone_reason"),
+		CreatedAt:       row.time("created_at"),
+		RepublishAt:     row.time("republish_at"),
+		Status:          row.get("status"),
+		PublishedAt:     row.optionalTime("published_at"),
+		PostedTweetID:   row.optionalString("posted_tweet_id"),
+		PostedOutcome:   row.optionalString("posted_outcome"),
+	}
+	if ids := row.get("edit_history_ids"); ids != "" {
+		r.EditHistoryIDs = strings.Split(ids, ",")
+	}
+	row.json("parts", &r.Parts)
+	row.json("milestones", &r.Milestones)
+	row.json("collection", &r.Collection)
+
+	if row.err != nil {
+		return nil, row.err
+	}
+	return r, nil
+}
+
+func jsonColumn(v any) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if string(raw) == "null" {
+		return "", nil
+	}
+	return string(raw), nil
+}
+
+func timeColumn(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func intColumn(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func stringColumn(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,105 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// ExportOptions describes what an export writes
+type ExportOptions struct {
+	Format     string // FORMAT_JSONL or FORMAT_CSV
+	Filter     storage.CapsuleFilter
+	ExportedAt time.Time
+}
+
+// Export streams the capsules matching the filter to w, oldest first, and
+// returns how many it wrote. JSON Lines exports start with a header record
+// and end with the key_value table and the tombstones of erased tweets; CSV
+// exports hold capsules only.
+func Export(store *storage.CapsuleStore, w io.Writer, opts ExportOptions) (int, error) {
+	switch opts.Format {
+	case FORMAT_JSONL:
+		return exportJSONL(store, w, opts)
+	case FORMAT_CSV:
+		return exportCSV(store, w, opts)
+	default:
+		return 0, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+}
+
+func exportJSONL(store *storage.CapsuleStore, w io.Writer, opts ExportOptions) (int, error) {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(header{
+		Type:       RECORD_HEADER,
+		Format:     FORMAT_NAME,
+		Version:    FORMAT_VERSION,
+		ExportedAt: opts.ExportedAt.UTC(),
+	}); err != nil {
+		return 0, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	count := 0
+	err := store.ExportCapsules(opts.Filter, func(c *storage.Capsule, collection *storage.Collection) error {
+		count++
+		return encoder.Encode(capsuleLine{Type: RECORD_CAPSULE, capsuleRecord: newCapsuleRecord(c, collection)})
+	})
+	if err != nil {
+		return count, fmt.Errorf("failed to export capsules: %w", err)
+	}
+
+	values, err := store.ListValues()
+	if err != nil {
+		return count, fmt.Errorf("failed to export state: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if err := encoder.Encode(keyValueLine{Type: RECORD_KEYVALUE, Key: key, Value: values[key]}); err != nil {
+			return count, fmt.Errorf("failed to export state: %w", err)
+		}
+	}
+
+	erased, err := store.ErasedTweets()
+	if err != nil {
+		return count, fmt.Errorf("failed to export erased tweets: %w", err)
+	}
+	for _, tweetID := range erased {
+		if err := encoder.Encode(erasedLine{Type: RECORD_ERASED, TweetID: tweetID}); err != nil {
+			return count, fmt.Errorf("failed to export erased tweets: %w", err)
+		}
+	}
+
+	return count, buffered.Flush()
+}
+
+func exportCSV(store *storage.CapsuleStore, w io.Writer, opts ExportOptions) (int, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return 0, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	count := 0
+	err := store.ExportCapsules(opts.Filter, func(c *storage.Capsule, collection *storage.Collection) error {
+		row, err := newCapsuleRecord(c, collection).csvRow()
+		if err != nil {
+			return err
+		}
+		count++
+		return writer.Write(row)
+	})
+	if err != nil {
+		return count, fmt.Errorf("failed to export capsules: %w", err)
+	}
+
+	writer.Flush()
+	return count, writer.Error()
+}
@@ -0,0 +1,273 @@
+// Package transfer moves capsules and bot state in and out of the database
+// as versioned JSON Lines or CSV
+package transfer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+const (
+	// FORMAT_NAME and FORMAT_VERSION identify the layout of an export.
+	// Imports read every version up to FORMAT_VERSION.
+	FORMAT_NAME    = "memento-export"
+	FORMAT_VERSION = 1
+
+	FORMAT_JSONL = "jsonl"
+	FORMAT_CSV   = "csv"
+)
+
+// Kinds of JSON Lines records
+const (
+	RECORD_HEADER   = "header"
+	RECORD_CAPSULE  = "capsule"
+	RECORD_KEYVALUE = "key_value"
+	RECORD_ERASED   = "erased_tweet"
+)
+
+// header is the first record of a JSON Lines export
+type header struct {
+	Type       string    `json:"type"`
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// capsuleLine, keyValueLine and erasedLine are the records that follow the header of a
+// JSON Lines export, told apart by their type
+type capsuleLine struct {
+	Type string `json:"type"`
+	*capsuleRecord
+}
+
+type keyValueLine struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// erasedLine is the tombstone of an erased tweet, so an import can't bring
+// it back
+type erasedLine struct {
+	Type    string `json:"type"`
+	TweetID string `json:"tweet_id"`
+}
+
+// capsuleRecord is a capsule without its database IDs, so it can be
+// imported into another database
+type capsuleRecord struct {
+	TweetID         string            `json:"tweet_id"`
+	RequesterID     string            `json:"requester_id"`
+	RequesterHandle string            `json:"requester_handle"`
+	TweetAuthor     string            `json:"tweet_author"`
+	TweetAuthorID   string            `json:"tweet_author_id"`
+	TweetAuthorName string            `json:"tweet_author_name"`
+	TweetText       string            `json:"tweet_text"`
+	IsReply         bool              `json:"is_reply"`
+	IsThread        bool              `json:"is_thread"`
+	Recurring       bool              `json:"recurring"`
+	RecurUntil      *time.Time        `json:"recur_until"`
+	EditHistoryIDs  []string          `json:"edit_history_ids"`
+	EditableUntil   *time.Time        `json:"editable_until"`
+	EditsRemaining  *int              `json:"edits_remaining"`
+	Attempts        int               `json:"attempts"`
+	LastError       *string           `json:"last_error"`
+	NextAttemptAt   *time.Time        `json:"next_attempt_at"`
+	DeliveryTZ      string            `json:"delivery_tz"`
+	LastCheckedAt   *time.Time        `json:"last_checked_at"`
+	LastSeenAliveAt *time.Time        `json:"last_seen_alive_at"`
+	GoneAt          *time.Time        `json:"gone_at"`
+	GoneReason      *string           `json:"gone_reason"`
+	CreatedAt       time.Time         `json:"created_at"`
+	RepublishAt     time.Time         `json:"republish_at"`
+	Status          string            `json:"status"`
+	PublishedAt     *time.Time        `json:"published_at"`
+	PostedTweetID   *string           `json:"posted_tweet_id"`
+	PostedOutcome   *string           `json:"posted_outcome"`
+	Parts           []partRecord      `json:"parts"`
+	Milestones      []milestoneRecord `json:"milestones"`
+	Collection      *collectionRecord `json:"collection"`
+}
+
+type partRecord struct {
+	Position  int    `json:"position"`
+	TweetID   string `json:"tweet_id"`
+	TweetText string `json:"tweet_text"`
+}
+
+type milestoneRecord struct {
+	Years         int        `json:"years"`
+	DueAt         time.Time  `json:"due_at"`
+	Status        string     `json:"status"`
+	PostedTweetID *string    `json:"posted_tweet_id"`
+	PublishedAt   *time.Time `json:"published_at"`
+}
+
+type collectionRecord struct {
+	OwnerID       string     `json:"owner_id"`
+	OwnerHandle   string     `json:"owner_handle"`
+	Name          string     `json:"name"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RevealAt      time.Time  `json:"reveal_at"`
+	Status        string     `json:"status"`
+	PublishedAt   *time.Time `json:"published_at"`
+	ThreadTweetID *string    `json:"thread_tweet_id"`
+	ThreadTotal   *int       `json:"thread_total"`
+	ThreadPosted  int        `json:"thread_posted"`
+	Attempts      int        `json:"attempts"`
+	LastError     *string    `json:"last_error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+}
+
+func newCapsuleRecord(c *storage.Capsule, collection *storage.Collection) *capsuleRecord {
+	r := &capsuleRecord{
+		TweetID:         c.TweetID,
+		RequesterID:     c.RequesterID,
+		RequesterHandle: c.RequesterHandle,
+		TweetAuthor:     c.TweetAuthor,
+		TweetAuthorID:   c.TweetAuthorID,
+		TweetAuthorName: c.TweetAuthorName,
+		TweetText:       c.TweetText,
+		IsReply:         c.IsReply,
+		IsThread:        c.IsThread,
+		Recurring:       c.Recurring,
+		RecurUntil:      utc(c.RecurUntil),
+		EditHistoryIDs:  c.EditHistoryIDs,
+		EditableUntil:   utc(c.EditableUntil),
+		EditsRemaining:  c.EditsRemaining,
+		Attempts:        c.Attempts,
+		LastError:       c.LastError,
+		NextAttemptAt:   utc(c.NextAttemptAt),
+		DeliveryTZ:      c.DeliveryTZ,
+		LastCheckedAt:   utc(c.LastCheckedAt),
+		LastSeenAliveAt: utc(c.LastSeenAliveAt),
+		GoneAt:          utc(c.GoneAt),
+		GoneReason:      c.GoneReason,
+		CreatedAt:       c.CreatedAt.UTC(),
+		RepublishAt:     c.RepublishAt.UTC(),
+		Status:          string(c.Status),
+		PublishedAt:     utc(c.PublishedAt),
+		PostedTweetID:   c.PostedTweetID,
+	}
+	if c.PostedOutcome != nil {
+		outcome := string(*c.PostedOutcome)
+		r.PostedOutcome = &outcome
+	}
+	for _, p := range c.Parts {
+		r.Parts = append(r.Parts, partRecord{Position: p.Position, TweetID: p.TweetID, TweetText: p.TweetText})
+	}
+	for _, m := range c.Milestones {
+		r.Milestones = append(r.Milestones, milestoneRecord{
+			Years:         m.Years,
+			DueAt:         m.DueAt.UTC(),
+			Status:        m.Status,
+			PostedTweetID: m.PostedTweetID,
+			PublishedAt:   utc(m.PublishedAt),
+		})
+	}
+	if collection != nil {
+		r.Collection = &collectionRecord{
+			OwnerID:       collection.OwnerID,
+			OwnerHandle:   collection.OwnerHandle,
+			Name:          collection.Name,
+			CreatedAt:     collection.CreatedAt.UTC(),
+			RevealAt:      collection.RevealAt.UTC(),
+			Status:        string(collection.Status),
+			PublishedAt:   utc(collection.PublishedAt),
+			ThreadTweetID: collection.ThreadTweetID,
+			ThreadTotal:   collection.ThreadTotal,
+			ThreadPosted:  collection.ThreadPosted,
+			Attempts:      collection.Attempts,
+			LastError:     collection.LastError,
+			NextAttemptAt: utc(collection.NextAttemptAt),
+		}
+	}
+	return r
+}
+
+// capsule turns the record back into a capsule and its collection
+func (r *capsuleRecord) capsule() (*storage.Capsule, *storage.Collection, error) {
+	if r.TweetID == "" || r.RequesterID == "" || r.Status == "" || r.CreatedAt.IsZero() || r.RepublishAt.IsZero() {
+		return nil, nil, fmt.Errorf("capsule record misses tweet_id, requester_id, status, created_at or republish_at")
+	}
+
+	c := &storage.Capsule{
+		TweetID:         r.TweetID,
+		RequesterID:     r.RequesterID,
+		RequesterHandle: r.RequesterHandle,
+		TweetAuthor:     r.TweetAuthor,
+		TweetAuthorID:   r.TweetAuthorID,
+		TweetAuthorName: r.TweetAuthorName,
+		TweetText:       r.TweetText,
+		IsReply:         r.IsReply,
+		IsThread:        r.IsThread,
+		Recurring:       r.Recurring,
+		RecurUntil:      r.RecurUntil,
+		EditHistoryIDs:  r.EditHistoryIDs,
+		EditableUntil:   r.EditableUntil,
+		EditsRemaining:  r.EditsRemaining,
+		Attempts:        r.Attempts,
+		LastError:       r.LastError,
+		NextAttemptAt:   r.NextAttemptAt,
+		DeliveryTZ:      r.DeliveryTZ,
+		LastCheckedAt:   r.LastCheckedAt,
+		LastSeenAliveAt: r.LastSeenAliveAt,
+		GoneAt:          r.GoneAt,
+		GoneReason:      r.GoneReason,
+		CreatedAt:       r.CreatedAt,
+		RepublishAt:     r.RepublishAt,
+		Status:          storage.CapsuleStatus(r.Status),
+		PublishedAt:     r.PublishedAt,
+		PostedTweetID:   r.PostedTweetID,
+	}
+	if r.PostedOutcome != nil {
+		outcome := storage.CapsuleStatus(*r.PostedOutcome)
+		c.PostedOutcome = &outcome
+	}
+	for _, p := range r.Parts {
+		c.Parts = append(c.Parts, storage.CapsulePart{Position: p.Position, TweetID: p.TweetID, TweetText: p.TweetText})
+	}
+	for _, m := range r.Milestones {
+		if m.Status == "" {
+			m.Status = "pending"
+		}
+		c.Milestones = append(c.Milestones, storage.Milestone{
+			Years:         m.Years,
+			DueAt:         m.DueAt,
+			Status:        m.Status,
+			PostedTweetID: m.PostedTweetID,
+			PublishedAt:   m.PublishedAt,
+		})
+	}
+
+	var collection *storage.Collection
+	if r.Collection != nil {
+		collection = &storage.Collection{
+			OwnerID:       r.Collection.OwnerID,
+			OwnerHandle:   r.Collection.OwnerHandle,
+			Name:          r.Collection.Name,
+			CreatedAt:     r.Collection.CreatedAt,
+			RevealAt:      r.Collection.RevealAt,
+			Status:        storage.CollectionStatus(r.Collection.Status),
+			PublishedAt:   r.Collection.PublishedAt,
+			ThreadTweetID: r.Collection.ThreadTweetID,
+			ThreadTotal:   r.Collection.ThreadTotal,
+			ThreadPosted:  r.Collection.ThreadPosted,
+			Attempts:      r.Collection.Attempts,
+			LastError:     r.Collection.LastError,
+			NextAttemptAt: r.Collection.NextAttemptAt,
+		}
+	}
+
+	return c, collection, nil
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
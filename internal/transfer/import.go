@@ -0,0 +1,194 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jvsena42/memento/internal/storage"
+)
+
+// maxLineSize bounds a JSON Lines record, a capsule with its whole thread
+const maxLineSize = 4 << 20
+
+// ImportOptions describes what an import reads
+type ImportOptions struct {
+	Format string // FORMAT_JSONL or FORMAT_CSV
+	Filter storage.CapsuleFilter
+}
+
+// ImportResult counts what an import did
+type ImportResult struct {
+	Imported int // capsules inserted
+	Skipped  int // capsules whose tweet was already saved or erased
+	Filtered int // capsules left out by the filter
+	Values   int // key_value entries set
+	Erased   int // tombstones of erased tweets added
+}
+
+// Import reads an export from r into the database. Capsules are keyed by
+// tweet ID and key_value entries by key; neither overwrites what the
+// database already holds, so running an import twice changes nothing.
+// Erased tweets are never imported, and their tombstones carry over.
+func Import(store *storage.CapsuleStore, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	switch opts.Format {
+	case FORMAT_JSONL:
+		return importJSONL(store, r, opts)
+	case FORMAT_CSV:
+		return importCSV(store, r, opts)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+}
+
+func importJSONL(store *storage.CapsuleStore, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	line := 0
+	sawHeader := false
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+
+		if !sawHeader {
+			var h header
+			if err := json.Unmarshal(raw, &h); err != nil || h.Type != RECORD_HEADER || h.Format != FORMAT_NAME {
+				return result, fmt.Errorf("line %d: not a %s file", line, FORMAT_NAME)
+			}
+			if h.Version < 1 || h.Version > FORMAT_VERSION {
+				return result, fmt.Errorf("line %d: unsupported format version %d", line, h.Version)
+			}
+			sawHeader = true
+			continue
+		}
+
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch kind.Type {
+		case RECORD_CAPSULE:
+			var rec capsuleRecord
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := importCapsule(store, &rec, opts.Filter, result); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+		case RECORD_KEYVALUE:
+			var rec keyValueLine
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			set, err := store.SetValueIfMissing(rec.Key, rec.Value)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			if set {
+				result.Values++
+			}
+		case RECORD_ERASED:
+			var rec erasedLine
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			if rec.TweetID == "" {
+				return result, fmt.Errorf("line %d: erased tweet record misses tweet_id", line)
+			}
+			added, err := store.TombstoneTweet(rec.TweetID)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			if added {
+				result.Erased++
+			}
+		default:
+			return result, fmt.Errorf("line %d: unknown record type %q", line, kind.Type)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read import: %w", err)
+	}
+	if !sawHeader {
+		return result, errors.New("import is empty")
+	}
+
+	return result, nil
+}
+
+func importCSV(store *storage.CapsuleStore, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	columns, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return result, errors.New("import is empty")
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[column] = i
+	}
+	if _, ok := index["format_version"]; !ok {
+		return result, fmt.Errorf("header has no format_version column, not a %s file", FORMAT_NAME)
+	}
+
+	for {
+		values, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read row: %w", err)
+		}
+
+		row := &csvRow{index: index, values: values}
+		line, _ := reader.FieldPos(0)
+		rec, err := row.capsuleRecord()
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := importCapsule(store, rec, opts.Filter, result); err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+func importCapsule(store *storage.CapsuleStore, rec *capsuleRecord, filter storage.CapsuleFilter, result *ImportResult) error {
+	c, collection, err := rec.capsule()
+	if err != nil {
+		return err
+	}
+
+	if !filter.Matches(c) {
+		result.Filtered++
+		return nil
+	}
+
+	imported, err := store.ImportCapsule(c, collection)
+	if err != nil {
+		return err
+	}
+	if imported {
+		result.Imported++
+	} else {
+		result.Skipped++
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/dghubble/oauth1"
 
+	"github.com/jvsena42/memento/internal/clock"
 	"github.com/jvsena42/memento/internal/config"
 )
 
@@ -23,6 +24,8 @@ type Client struct {
 	BotUserID     string
 	BaseUrl       string
 	SinceID       string
+	DryRun        PostRecorder // when set, tweets are recorded instead of posted
+	Clock         clock.Clock  // waits between retries and for rate limits to reset
 }
 
 func NewClient(cfg *config.Config) *Client {
@@ -38,6 +41,7 @@ func NewClient(cfg *config.Config) *Client {
 		BaseUrl:       "https://api.twitter.com",
 		BotUserID:     cfg.BotUserID,
 		SinceID:       "",
+		Clock:         clock.System,
 	}
 }
 
@@ -96,7 +100,7 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method string, url stri
 
 		if err != nil {
 			slog.Warn("request creation failed", "error", err)
-			time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+			c.Clock.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
 			continue
 		}
 
@@ -107,7 +111,7 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method string, url stri
 
 		//Network error
 		if err != nil {
-			time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+			c.Clock.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
 			continue
 		}
 
@@ -115,15 +119,15 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method string, url stri
 		if statusCode == 429 {
 			resetStr := header.Get("x-rate-limit-reset")
 			resetUnix, _ := strconv.ParseInt(resetStr, 10, 64)
-			waitTime := time.Until(time.Unix(resetUnix, 0)) + 1*time.Second
+			waitTime := time.Unix(resetUnix, 0).Sub(c.Clock.Now()) + 1*time.Second
 			slog.Warn("rate limited, waiting", "seconds", waitTime.Seconds())
-			time.Sleep(waitTime)
+			c.Clock.Sleep(waitTime)
 			continue
 		}
 
 		// Server error -> wait and retry
 		if statusCode >= 500 {
-			time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+			c.Clock.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
 			continue
 		}
 
@@ -137,12 +141,12 @@ func (c *Client) doRequestWithRetry(ctx context.Context, method string, url stri
 
 		// Other client errors (400, 401, etc.) → don't retry
 		if statusCode < 200 || statusCode >= 300 {
-			return nil, fmt.Errorf("api error (status %d): %s", statusCode, string(respBody))
+			return nil, &StatusError{StatusCode: statusCode, Body: string(respBody)}
 		}
 
 		// Success
 		return respBody, nil
 	}
 
-	return nil, fmt.Errorf("max retries exceeded")
+	return nil, ErrMaxRetries
 }
@@ -1,8 +1,39 @@
 package twitter
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrNotFound  = errors.New("tweet not found or deleted")
-	ErrForbidden = errors.New("tweet is protected or account suspended")
+	ErrNotFound   = errors.New("tweet not found or deleted")
+	ErrForbidden  = errors.New("tweet is protected or account suspended")
+	ErrMaxRetries = errors.New("max retries exceeded")
 )
+
+// StatusError is returned for client errors the API won't recover from by
+// itself, such as 400 or 401
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// IsRetryable reports whether a request that failed with err may succeed
+// later. Network errors, server errors and exhausted rate limit retries are
+// retryable; missing or protected tweets and other client errors are not.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == 429
+	}
+
+	return true
+}
@@ -1,26 +1,84 @@
 package twitter
 
 type Tweet struct {
-	ID              string  `json:"id"`
-	AuthorID        string  `json:"author_id"`
-	Text            string  `json:"text"`
-	CreatedAt       string  `json:"created_at"`
-	ConversationID  string  `json:"conversation_id"`
-	InReplyToUserID *string `json:"in_reply_to_user_id"`
+	ID               string            `json:"id"`
+	AuthorID         string            `json:"author_id"`
+	Text             string            `json:"text"`
+	CreatedAt        string            `json:"created_at"`
+	ConversationID   string            `json:"conversation_id"`
+	InReplyToUserID  *string           `json:"in_reply_to_user_id"`
+	Entities         *Entities         `json:"entities"`
+	ReferencedTweets []ReferencedTweet `json:"referenced_tweets"`
+	// EditHistoryTweetIDs lists every version of the tweet, oldest first
+	EditHistoryTweetIDs []string      `json:"edit_history_tweet_ids"`
+	EditControls        *EditControls `json:"edit_controls"`
+}
+
+type EditControls struct {
+	EditsRemaining int    `json:"edits_remaining"`
+	IsEditEligible bool   `json:"is_edit_eligible"`
+	EditableUntil  string `json:"editable_until"`
+}
+
+// LatestVersionID returns the ID of the newest version of the tweet
+func (t Tweet) LatestVersionID() string {
+	if len(t.EditHistoryTweetIDs) == 0 {
+		return t.ID
+	}
+	return t.EditHistoryTweetIDs[len(t.EditHistoryTweetIDs)-1]
+}
+
+// ReferencedTweet links a tweet to the tweet it replies to, quotes or retweets.
+type ReferencedTweet struct {
+	Type string `json:"type"` // replied_to, quoted or retweeted
+	ID   string `json:"id"`
+}
+
+// RepliedToID returns the ID of the tweet this tweet replies to, or an empty
+// string for root tweets.
+func (t Tweet) RepliedToID() string {
+	for _, ref := range t.ReferencedTweets {
+		if ref.Type == "replied_to" {
+			return ref.ID
+		}
+	}
+	return ""
+}
+
+type Entities struct {
+	URLs []URLEntity `json:"urls"`
+}
+
+// URLEntity is a link found in the tweet text. URL holds the t.co short link,
+// ExpandedURL the address it resolves to.
+type URLEntity struct {
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	URL         string `json:"url"`
+	ExpandedURL string `json:"expanded_url"`
+	DisplayURL  string `json:"display_url"`
 }
 
 type User struct {
 	ID       string `json:"id"`
+	Name     string `json:"name"`
 	UserName string `json:"username"`
 }
 
 type APIError struct {
-	Title  string `json:"title"`
-	Type   string `json:"type"`
-	Detail string `json:"detail"`
-	Status int    `json:"status"`
+	Title      string `json:"title"`
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+	Status     int    `json:"status"`
+	ResourceID string `json:"resource_id"`
 }
 
+// Problem types reported for tweets missing from a lookup
+const (
+	ProblemResourceNotFound = "https://api.twitter.com/2/problems/resource-not-found"
+	ProblemNotAuthorized    = "https://api.twitter.com/2/problems/not-authorized-for-resource"
+)
+
 type Meta struct {
 	NewestID      string `json:"newest_id"`
 	NextToken     string `json:"next_token"`
@@ -0,0 +1,51 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// SearchRecent runs a query against the recent search endpoint, which only
+// covers tweets from the last seven days, following pagination.
+func (c *Client) SearchRecent(ctx context.Context, query string) (*TweetsResponse, error) {
+	params := map[string]string{
+		"query":        query,
+		"tweet.fields": tweetFields,
+		"expansions":   "author_id",
+		"max_results":  "100",
+	}
+
+	var allTweets []Tweet
+	var allUsers []User
+	maxPages := 10
+	var response TweetsResponse
+	for page := 0; page < maxPages; page++ {
+		respBytes, err := c.doGet(ctx, "/2/tweets/search/recent", params)
+		if err != nil {
+			return nil, err
+		}
+		response = TweetsResponse{}
+		if err := json.Unmarshal(respBytes, &response); err != nil {
+			return nil, err
+		}
+
+		allTweets = append(allTweets, response.Tweets...)
+
+		if response.Includes != nil {
+			allUsers = append(allUsers, response.Includes.Users...)
+		}
+
+		if response.Meta == nil || response.Meta.NextToken == "" {
+			break
+		}
+
+		params["next_token"] = response.Meta.NextToken
+	}
+
+	response.Tweets = allTweets
+	response.Includes = &Includes{
+		Users: allUsers,
+	}
+
+	return &response, nil
+}
@@ -3,8 +3,17 @@ package twitter
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
 )
 
+// tweetFields lists the tweet fields requested on every tweet lookup
+const tweetFields = "author_id,text,created_at,conversation_id,in_reply_to_user_id,entities,referenced_tweets,edit_history_tweet_ids,edit_controls"
+
+// maxLookupIDs is the maximum number of IDs accepted by the tweets lookup endpoint
+const maxLookupIDs = 100
+
 type PostTweetRequest struct {
 	Text         string       `json:"text"`
 	QuoteTweetID string       `json:"quote_tweet_id,omitempty"`
@@ -17,7 +26,7 @@ type ReplyConfig struct {
 
 func (c *Client) GetTweet(ctx context.Context, id string) (*TweetResponse, error) {
 	params := map[string]string{
-		"tweet.fields": "author_id,text,created_at,conversation_id,in_reply_to_user_id",
+		"tweet.fields": tweetFields,
 		"expansions":   "author_id",
 	}
 	respBytes, err := c.doGet(ctx, "/2/tweets/"+id, params)
@@ -33,7 +42,51 @@ func (c *Client) GetTweet(ctx context.Context, id string) (*TweetResponse, error
 	return &response, nil
 }
 
+// GetTweets looks up several tweets at once. Tweets that were deleted or are
+// no longer visible are missing from the data and reported in Errors instead.
+func (c *Client) GetTweets(ctx context.Context, ids []string) (*TweetsResponse, error) {
+	var response TweetsResponse
+	response.Includes = &Includes{}
+
+	for start := 0; start < len(ids); start += maxLookupIDs {
+		end := min(start+maxLookupIDs, len(ids))
+		params := map[string]string{
+			"ids":          strings.Join(ids[start:end], ","),
+			"tweet.fields": tweetFields,
+			"expansions":   "author_id",
+		}
+
+		respBytes, err := c.doGet(ctx, "/2/tweets", params)
+		if err != nil {
+			return nil, err
+		}
+
+		var page TweetsResponse
+		if err := json.Unmarshal(respBytes, &page); err != nil {
+			return nil, err
+		}
+
+		response.Tweets = append(response.Tweets, page.Tweets...)
+		response.Errors = append(response.Errors, page.Errors...)
+		if page.Includes != nil {
+			response.Includes.Users = append(response.Includes.Users, page.Includes.Users...)
+		}
+	}
+
+	return &response, nil
+}
+
+// PostRecorder keeps the tweets a dry-run client would have posted and
+// returns a synthetic ID for each of them
+type PostRecorder interface {
+	RecordDryRunPost(text string, quoteTweetID string, replyToID string) (string, error)
+}
+
 func (c *Client) PostTweet(ctx context.Context, text string, quoteTweetID string, replyToID string) (*TweetResponse, error) {
+	if c.DryRun != nil {
+		return c.recordDryRun(text, quoteTweetID, replyToID)
+	}
+
 	request := PostTweetRequest{
 		Text: text,
 	}
@@ -60,3 +113,21 @@ func (c *Client) PostTweet(ctx context.Context, text string, quoteTweetID string
 
 	return &response, nil
 }
+
+// recordDryRun logs and records a tweet instead of posting it
+func (c *Client) recordDryRun(text string, quoteTweetID string, replyToID string) (*TweetResponse, error) {
+	id, err := c.DryRun.RecordDryRunPost(text, quoteTweetID, replyToID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record dry-run post: %w", err)
+	}
+
+	slog.Info("dry run: would post tweet", "id", id, "text", text, "quote_tweet_id", quoteTweetID, "reply_to_id", replyToID)
+
+	return &TweetResponse{
+		Tweet: Tweet{
+			ID:       id,
+			AuthorID: c.BotUserID,
+			Text:     text,
+		},
+	}, nil
+}
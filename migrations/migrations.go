@@ -0,0 +1,9 @@
+// Package migrations embeds the schema history of every database dialect.
+// Each migration is a NNN_name.sql file applied going up, optionally paired
+// with a NNN_name.down.sql file that undoes it.
+package migrations
+
+import "embed"
+
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
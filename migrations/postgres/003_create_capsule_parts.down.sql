@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS capsule_parts;
+
+ALTER TABLE capsules DROP COLUMN is_thread;
@@ -0,0 +1,10 @@
+ALTER TABLE capsules ADD COLUMN is_thread BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Tweets of a captured thread, in reading order. Position 0 is the root.
+CREATE TABLE IF NOT EXISTS capsule_parts (
+    capsule_id BIGINT  NOT NULL REFERENCES capsules (id) ON DELETE CASCADE,
+    position   INTEGER NOT NULL,
+    tweet_id   TEXT    NOT NULL,
+    tweet_text TEXT    NOT NULL,
+    PRIMARY KEY (capsule_id, position)
+);
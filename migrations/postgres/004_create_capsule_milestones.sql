@@ -0,0 +1,15 @@
+-- Anniversaries at which a capsule is republished. The capsule republish_at
+-- always points at the earliest pending milestone.
+CREATE TABLE IF NOT EXISTS capsule_milestones (
+    id              BIGSERIAL PRIMARY KEY,
+    capsule_id      BIGINT    NOT NULL REFERENCES capsules (id) ON DELETE CASCADE,
+    years           INTEGER   NOT NULL,
+    due_at          TIMESTAMP NOT NULL,
+    status          TEXT      NOT NULL DEFAULT 'pending',
+    posted_tweet_id TEXT,
+    published_at    TIMESTAMP,
+    UNIQUE (capsule_id, years)
+);
+
+CREATE INDEX IF NOT EXISTS idx_capsule_milestones_capsule
+    ON capsule_milestones (capsule_id, status, due_at);
@@ -0,0 +1,2 @@
+ALTER TABLE capsules DROP COLUMN recurring;
+ALTER TABLE capsules DROP COLUMN recur_until;
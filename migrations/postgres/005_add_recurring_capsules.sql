@@ -0,0 +1,3 @@
+-- Recurring capsules come back on every anniversary until recur_until
+ALTER TABLE capsules ADD COLUMN recurring BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE capsules ADD COLUMN recur_until TIMESTAMP;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_capsules_collection;
+
+ALTER TABLE capsules DROP COLUMN collection_id;
+
+DROP TABLE IF EXISTS collections;
@@ -0,0 +1,23 @@
+-- Named collections of capsules owned by a requester, republished together
+CREATE TABLE IF NOT EXISTS collections (
+    id           BIGSERIAL PRIMARY KEY,
+    owner_id     TEXT      NOT NULL,
+    owner_handle TEXT      NOT NULL,
+    name         TEXT      NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+    reveal_at    TIMESTAMP NOT NULL,
+    status       TEXT      NOT NULL DEFAULT 'pending',
+    published_at TIMESTAMP
+);
+
+-- An owner has at most one open collection with a given name
+CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_owner_name
+    ON collections (owner_id, name) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_collections_reveal
+    ON collections (status, reveal_at);
+
+ALTER TABLE capsules ADD COLUMN collection_id BIGINT REFERENCES collections (id);
+
+CREATE INDEX IF NOT EXISTS idx_capsules_collection
+    ON capsules (collection_id);
@@ -0,0 +1,5 @@
+-- Edit state of the tweet at capture time. edit_history_ids is a
+-- comma-separated list of tweet IDs, oldest version first.
+ALTER TABLE capsules ADD COLUMN edit_history_ids TEXT NOT NULL DEFAULT '';
+ALTER TABLE capsules ADD COLUMN editable_until TIMESTAMP;
+ALTER TABLE capsules ADD COLUMN edits_remaining INTEGER;
@@ -0,0 +1,4 @@
+ALTER TABLE capsules DROP COLUMN posted_tweet_id;
+ALTER TABLE capsules DROP COLUMN attempts;
+ALTER TABLE capsules DROP COLUMN last_error;
+ALTER TABLE capsules DROP COLUMN next_attempt_at;
@@ -0,0 +1,11 @@
+-- Failed publish attempts. A capsule whose attempt failed with a retryable
+-- error is retried from next_attempt_at on; once it runs out of attempts it
+-- is left in the 'dead' status with its last error for operators to inspect.
+ALTER TABLE capsules ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE capsules ADD COLUMN last_error TEXT;
+ALTER TABLE capsules ADD COLUMN next_attempt_at TIMESTAMP;
+
+-- A republish is recorded as soon as it is posted, before the capsule is
+-- completed. A capsule whose completion failed keeps it and is completed
+-- without posting again when it comes up next.
+ALTER TABLE capsules ADD COLUMN posted_tweet_id TEXT;
@@ -0,0 +1,5 @@
+ALTER TABLE capsules DROP COLUMN lease_owner;
+ALTER TABLE capsules DROP COLUMN lease_expires_at;
+
+ALTER TABLE collections DROP COLUMN lease_owner;
+ALTER TABLE collections DROP COLUMN lease_expires_at;
@@ -0,0 +1,5 @@
+ALTER TABLE collections DROP COLUMN thread_tweet_id;
+ALTER TABLE collections DROP COLUMN thread_total;
+ALTER TABLE collections DROP COLUMN thread_posted;
+ALTER TABLE collections DROP COLUMN next_attempt_at;
+DROP TABLE IF EXISTS post_log;
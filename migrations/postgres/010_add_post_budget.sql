@@ -0,0 +1,21 @@
+-- Every tweet posted by the bot, replies and republishes alike, used to keep
+-- within the daily and monthly post limits of the API tier
+CREATE TABLE IF NOT EXISTS post_log (
+    id        BIGSERIAL PRIMARY KEY,
+    kind      TEXT      NOT NULL,
+    tweet_id  TEXT      NOT NULL,
+    posted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_log_posted_at
+    ON post_log (posted_at);
+
+-- A collection thread is recorded as it is posted, so a thread cut short by
+-- the post budget resumes where it stopped: thread_tweet_id is the last post
+-- of the thread, thread_total the member count announced by its intro and
+-- thread_posted how many members made it. A deferred collection waits for
+-- next_attempt_at.
+ALTER TABLE collections ADD COLUMN thread_tweet_id TEXT;
+ALTER TABLE collections ADD COLUMN thread_total INTEGER;
+ALTER TABLE collections ADD COLUMN thread_posted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE collections ADD COLUMN next_attempt_at TIMESTAMP;
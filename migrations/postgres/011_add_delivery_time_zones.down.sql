@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_preferences;
+
+ALTER TABLE capsules DROP COLUMN delivery_tz;
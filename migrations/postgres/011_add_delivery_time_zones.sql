@@ -0,0 +1,8 @@
+-- Time zone in which a capsule is delivered, empty for the exact UTC instant
+ALTER TABLE capsules ADD COLUMN delivery_tz TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id    TEXT PRIMARY KEY,
+    time_zone  TEXT      NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
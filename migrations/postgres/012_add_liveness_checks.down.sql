@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_capsules_last_checked;
+
+ALTER TABLE capsules DROP COLUMN tweet_author_name;
+ALTER TABLE capsules DROP COLUMN last_checked_at;
+ALTER TABLE capsules DROP COLUMN last_seen_alive_at;
+ALTER TABLE capsules DROP COLUMN gone_at;
+ALTER TABLE capsules DROP COLUMN gone_reason;
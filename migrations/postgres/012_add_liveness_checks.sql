@@ -0,0 +1,8 @@
+-- Liveness of the saved tweet, checked in the background while the capsule waits
+ALTER TABLE capsules ADD COLUMN tweet_author_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE capsules ADD COLUMN last_checked_at TIMESTAMP;
+ALTER TABLE capsules ADD COLUMN last_seen_alive_at TIMESTAMP;
+ALTER TABLE capsules ADD COLUMN gone_at TIMESTAMP;
+ALTER TABLE capsules ADD COLUMN gone_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_capsules_last_checked ON capsules(status, last_checked_at);
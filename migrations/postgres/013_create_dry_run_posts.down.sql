@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dry_run_posts;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_capsules_search;
+
+DROP TRIGGER IF EXISTS capsule_parts_search_vector ON capsule_parts;
+DROP FUNCTION IF EXISTS capsule_parts_search_vector();
+DROP TRIGGER IF EXISTS capsules_search_vector ON capsules;
+DROP FUNCTION IF EXISTS capsules_search_vector();
+
+ALTER TABLE capsules DROP COLUMN search_vector;
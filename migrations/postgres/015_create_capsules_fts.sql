@@ -0,0 +1,43 @@
+-- Full-text search vector of capsules, weighted from the tweet snapshot down
+-- to the requester handle. Snapshots encrypted at rest are left out, or the
+-- index would hold them in plain text.
+ALTER TABLE capsules ADD COLUMN search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION capsules_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+    -- The root of a thread is part 0 and already indexed as the capsule text
+    NEW.search_vector :=
+        setweight(to_tsvector('english', CASE WHEN NEW.key_id IS NULL THEN NEW.tweet_text ELSE '' END), 'A') ||
+        setweight(to_tsvector('english', COALESCE((
+            SELECT string_agg(tweet_text, ' ') FROM capsule_parts
+            WHERE capsule_id = NEW.id AND position > 0 AND key_id IS NULL
+        ), '')), 'B') ||
+        setweight(to_tsvector('english', NEW.tweet_author || ' ' || NEW.tweet_author_name), 'C') ||
+        setweight(to_tsvector('english', NEW.requester_handle), 'D');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER capsules_search_vector
+BEFORE INSERT OR UPDATE OF tweet_text, key_id, tweet_author, tweet_author_name, requester_handle, search_vector ON capsules
+FOR EACH ROW EXECUTE FUNCTION capsules_search_vector();
+
+-- Parts change the vector of their capsule through the trigger above
+CREATE OR REPLACE FUNCTION capsule_parts_search_vector() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE capsules SET search_vector = NULL WHERE id = OLD.capsule_id;
+    ELSE
+        UPDATE capsules SET search_vector = NULL WHERE id = NEW.capsule_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER capsule_parts_search_vector
+AFTER INSERT OR UPDATE OF tweet_text, key_id OR DELETE ON capsule_parts
+FOR EACH ROW EXECUTE FUNCTION capsule_parts_search_vector();
+
+UPDATE capsules SET search_vector = NULL;
+
+CREATE INDEX IF NOT EXISTS idx_capsules_search ON capsules USING GIN (search_vector);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS erased_tweets;
+DROP TABLE IF EXISTS erasures;
+
+DROP INDEX IF EXISTS idx_capsules_tweet_author;
+
+ALTER TABLE capsules DROP COLUMN tweet_author_id;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS capsule_events_append_only ON capsule_events;
+DROP FUNCTION IF EXISTS capsule_events_append_only();
+DROP INDEX IF EXISTS idx_capsule_events_capsule;
+DROP TABLE IF EXISTS capsule_events;
@@ -0,0 +1,10 @@
+-- Capsule statuses follow a transition table since this migration. A capsule
+-- is 'publishing' while an instance holds its lease, and a republish posted
+-- from the snapshot of a deleted tweet ends in 'deleted_published', which
+-- replaces the 'deleted' status the bot documented but never set.
+UPDATE capsules SET status = 'deleted_published' WHERE status = 'deleted';
+UPDATE capsules SET status = 'publishing' WHERE status = 'pending' AND lease_owner IS NOT NULL;
+
+-- The status a posted republish completes the capsule with, recorded along
+-- with posted_tweet_id
+ALTER TABLE capsules ADD COLUMN posted_outcome TEXT;
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS key_value (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
\ No newline at end of file
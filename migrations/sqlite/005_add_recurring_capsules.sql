@@ -0,0 +1,3 @@
+-- Recurring capsules come back on every anniversary until recur_until
+ALTER TABLE capsules ADD COLUMN recurring BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE capsules ADD COLUMN recur_until TIMESTAMP;
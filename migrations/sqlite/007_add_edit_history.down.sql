@@ -0,0 +1,3 @@
+ALTER TABLE capsules DROP COLUMN edit_history_ids;
+ALTER TABLE capsules DROP COLUMN editable_until;
+ALTER TABLE capsules DROP COLUMN edits_remaining;
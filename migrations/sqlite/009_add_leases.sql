@@ -0,0 +1,8 @@
+-- Leases let several bot instances share the database: an instance claims a
+-- due capsule or collection by setting itself as lease_owner until
+-- lease_expires_at. Expired leases can be claimed again.
+ALTER TABLE capsules ADD COLUMN lease_owner TEXT;
+ALTER TABLE capsules ADD COLUMN lease_expires_at TIMESTAMP;
+
+ALTER TABLE collections ADD COLUMN lease_owner TEXT;
+ALTER TABLE collections ADD COLUMN lease_expires_at TIMESTAMP;
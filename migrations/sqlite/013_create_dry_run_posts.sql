@@ -0,0 +1,8 @@
+-- Tweets the bot would have posted while running with DRY_RUN=true
+CREATE TABLE IF NOT EXISTS dry_run_posts (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    text           TEXT      NOT NULL,
+    quote_tweet_id TEXT,
+    reply_to_id    TEXT,
+    created_at     TIMESTAMP NOT NULL
+);
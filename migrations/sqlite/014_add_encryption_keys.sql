@@ -0,0 +1,14 @@
+-- Data keys of the envelope encryption of tweet snapshots, wrapped by the
+-- master key that wrapped_by names. Retired keys are kept so older rows and
+-- backups stay readable.
+CREATE TABLE IF NOT EXISTS encryption_keys (
+    id          TEXT PRIMARY KEY,
+    wrapped_key TEXT      NOT NULL,
+    wrapped_by  TEXT      NOT NULL,
+    created_at  TIMESTAMP NOT NULL,
+    retired_at  TIMESTAMP
+);
+
+-- Data key that encrypted tweet_text, NULL while it is plain text
+ALTER TABLE capsules ADD COLUMN key_id TEXT REFERENCES encryption_keys (id);
+ALTER TABLE capsule_parts ADD COLUMN key_id TEXT REFERENCES encryption_keys (id);
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS capsule_parts_fts_delete;
+DROP TRIGGER IF EXISTS capsule_parts_fts_update;
+DROP TRIGGER IF EXISTS capsule_parts_fts_insert;
+DROP TRIGGER IF EXISTS capsules_fts_delete;
+DROP TRIGGER IF EXISTS capsules_fts_update;
+DROP TRIGGER IF EXISTS capsules_fts_insert;
+
+DROP TABLE IF EXISTS capsules_fts;
@@ -0,0 +1,67 @@
+-- Full-text index of capsules, one row per capsule keyed by its id. Snapshots
+-- encrypted at rest are left out, or the index would hold them in plain text.
+CREATE VIRTUAL TABLE IF NOT EXISTS capsules_fts USING fts5(
+    text,        -- snapshot of the tweet
+    thread,      -- snapshots of the other tweets of a thread
+    author,
+    author_name,
+    requester,
+    tokenize = 'porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS capsules_fts_insert AFTER INSERT ON capsules BEGIN
+    INSERT INTO capsules_fts (rowid, text, thread, author, author_name, requester)
+    VALUES (new.id, CASE WHEN new.key_id IS NULL THEN new.tweet_text ELSE '' END, '',
+        new.tweet_author, new.tweet_author_name, new.requester_handle);
+END;
+
+CREATE TRIGGER IF NOT EXISTS capsules_fts_update
+AFTER UPDATE OF tweet_text, key_id, tweet_author, tweet_author_name, requester_handle ON capsules BEGIN
+    UPDATE capsules_fts
+    SET text = CASE WHEN new.key_id IS NULL THEN new.tweet_text ELSE '' END,
+        author = new.tweet_author,
+        author_name = new.tweet_author_name,
+        requester = new.requester_handle
+    WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS capsules_fts_delete AFTER DELETE ON capsules BEGIN
+    DELETE FROM capsules_fts WHERE rowid = old.id;
+END;
+
+-- The root of a thread is part 0 and already indexed as the capsule text
+CREATE TRIGGER IF NOT EXISTS capsule_parts_fts_insert AFTER INSERT ON capsule_parts BEGIN
+    UPDATE capsules_fts
+    SET thread = COALESCE((
+        SELECT group_concat(tweet_text, ' ') FROM capsule_parts
+        WHERE capsule_id = new.capsule_id AND position > 0 AND key_id IS NULL
+    ), '')
+    WHERE rowid = new.capsule_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS capsule_parts_fts_update AFTER UPDATE OF tweet_text, key_id ON capsule_parts BEGIN
+    UPDATE capsules_fts
+    SET thread = COALESCE((
+        SELECT group_concat(tweet_text, ' ') FROM capsule_parts
+        WHERE capsule_id = new.capsule_id AND position > 0 AND key_id IS NULL
+    ), '')
+    WHERE rowid = new.capsule_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS capsule_parts_fts_delete AFTER DELETE ON capsule_parts BEGIN
+    UPDATE capsules_fts
+    SET thread = COALESCE((
+        SELECT group_concat(tweet_text, ' ') FROM capsule_parts
+        WHERE capsule_id = old.capsule_id AND position > 0 AND key_id IS NULL
+    ), '')
+    WHERE rowid = old.capsule_id;
+END;
+
+INSERT INTO capsules_fts (rowid, text, thread, author, author_name, requester)
+SELECT id, CASE WHEN key_id IS NULL THEN tweet_text ELSE '' END,
+    COALESCE((
+        SELECT group_concat(p.tweet_text, ' ') FROM capsule_parts p
+        WHERE p.capsule_id = capsules.id AND p.position > 0 AND p.key_id IS NULL
+    ), ''),
+    tweet_author, tweet_author_name, requester_handle
+FROM capsules;
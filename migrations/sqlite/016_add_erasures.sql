@@ -0,0 +1,26 @@
+-- Twitter user ID of the tweet author, so an erasure finds the tweets a user
+-- wrote. Empty for capsules captured before it was recorded.
+ALTER TABLE capsules ADD COLUMN tweet_author_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_capsules_tweet_author
+    ON capsules (tweet_author_id);
+
+-- Receipts of erasure requests. The user is only named by the SHA-256 of
+-- their ID, so a receipt holds no personal data.
+CREATE TABLE IF NOT EXISTS erasures (
+    id                    INTEGER PRIMARY KEY AUTOINCREMENT,
+    subject_hash          TEXT      NOT NULL,
+    erased_at             TIMESTAMP NOT NULL,
+    capsules_deleted      INTEGER   NOT NULL,
+    collections_deleted   INTEGER   NOT NULL,
+    preferences_deleted   INTEGER   NOT NULL,
+    dry_run_posts_deleted INTEGER   NOT NULL,
+    tweets_tombstoned     INTEGER   NOT NULL
+);
+
+-- Tombstones of erased tweets, which can't be captured again. The erasure
+-- is NULL for tombstones carried over by an import.
+CREATE TABLE IF NOT EXISTS erased_tweets (
+    tweet_id   TEXT PRIMARY KEY,
+    erasure_id INTEGER REFERENCES erasures (id)
+);
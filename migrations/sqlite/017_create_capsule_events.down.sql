@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS capsule_events_append_only;
+DROP INDEX IF EXISTS idx_capsule_events_capsule;
+DROP TABLE IF EXISTS capsule_events;
@@ -0,0 +1,22 @@
+-- Append-only log of what happened to each capsule, written in the same
+-- transaction as the change it records. Rows are only ever deleted along
+-- with their capsule, by an erasure.
+CREATE TABLE IF NOT EXISTS capsule_events (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    capsule_id  INTEGER   NOT NULL REFERENCES capsules (id),
+    event       TEXT      NOT NULL,
+    from_status TEXT      NOT NULL,
+    to_status   TEXT      NOT NULL,
+    actor       TEXT      NOT NULL, -- user:<id>, an instance ID, import or system
+    reason      TEXT,
+    error       TEXT,
+    tweet_ids   TEXT      NOT NULL DEFAULT '', -- related tweets, comma-separated
+    created_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_capsule_events_capsule
+    ON capsule_events (capsule_id, id);
+
+CREATE TRIGGER IF NOT EXISTS capsule_events_append_only BEFORE UPDATE ON capsule_events BEGIN
+    SELECT RAISE(ABORT, 'capsule_events is append-only');
+END;
@@ -0,0 +1,3 @@
+ALTER TABLE capsules DROP COLUMN posted_outcome;
+UPDATE capsules SET status = 'pending' WHERE status = 'publishing';
+UPDATE capsules SET status = 'deleted' WHERE status = 'deleted_published';
@@ -0,0 +1,3 @@
+UPDATE collections SET status = 'failed' WHERE status = 'dead';
+ALTER TABLE collections DROP COLUMN attempts;
+ALTER TABLE collections DROP COLUMN last_error;
@@ -0,0 +1,5 @@
+-- Failed publish attempts of collections, retried from next_attempt_at on
+-- like capsules. A collection out of attempts is left in the 'dead' status
+-- with its last error.
+ALTER TABLE collections ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE collections ADD COLUMN last_error TEXT;
@@ -0,0 +1,4 @@
+-- Data key that encrypted the text of a dry-run post, NULL while it is plain
+-- text. Dry-run posts quote the snapshots they republish, so they're
+-- encrypted with them.
+ALTER TABLE dry_run_posts ADD COLUMN key_id TEXT REFERENCES encryption_keys (id);